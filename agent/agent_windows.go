@@ -57,6 +57,8 @@ type WindowsAgent struct {
 	Logger        *logrus.Logger
 	Version       string
 	Debug         bool
+	MaxFileSize   int64
+	AllowedPaths  []string
 	rClient       *resty.Client
 }
 
@@ -90,6 +92,8 @@ func New(logger *logrus.Logger, version string) *WindowsAgent {
 		agentpk string
 		pk      int
 		cert    string
+		maxsize int64 = 500 * 1024 * 1024
+		allowed []string
 	)
 
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\TacticalRMM`, registry.ALL_ACCESS)
@@ -122,6 +126,13 @@ func New(logger *logrus.Logger, version string) *WindowsAgent {
 		pk, _ = strconv.Atoi(agentpk)
 
 		cert, _, _ = k.GetStringValue("Cert")
+
+		// optional file transfer limits, unrestricted paths if not set
+		maxmb, _, err := k.GetIntegerValue("FileTransferMaxMB")
+		if err == nil && maxmb > 0 {
+			maxsize = int64(maxmb) * 1024 * 1024
+		}
+		allowed, _, _ = k.GetStringsValue("AllowedPaths")
 	}
 
 	headers := make(map[string]string)
@@ -161,6 +172,8 @@ func New(logger *logrus.Logger, version string) *WindowsAgent {
 		Logger:        logger,
 		Version:       version,
 		Debug:         logger.IsLevelEnabled(logrus.DebugLevel),
+		MaxFileSize:   maxsize,
+		AllowedPaths:  allowed,
 		rClient:       restyC,
 	}
 }
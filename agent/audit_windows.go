@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	auditOnce   sync.Once
+	auditLogger *logrus.Logger
+)
+
+// auditLog returns the logger for ProgramDir\audit.log
+// Audit records are kept as json in their own file so they can be shipped and retained apart from agent.log
+func (a *WindowsAgent) auditLog() *logrus.Logger {
+	auditOnce.Do(func() {
+		auditLogger = logrus.New()
+		auditLogger.SetFormatter(&logrus.JSONFormatter{})
+		f, err := os.OpenFile(filepath.Join(a.ProgramDir, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			a.Logger.Errorln("Audit log:", err)
+			auditLogger.SetOutput(os.Stderr)
+			return
+		}
+		auditLogger.SetOutput(f)
+	})
+	return auditLogger
+}
+
+func (a *WindowsAgent) audit(fields logrus.Fields, err error) {
+	entry := a.auditLog().WithFields(fields)
+	if err != nil {
+		entry.Warnln(err)
+		return
+	}
+	entry.Infoln("ok")
+}
+
+// AuditLog records actions taken on the endpoint by remote users
+func (a *WindowsAgent) AuditLog(action, target string, err error) {
+	a.audit(logrus.Fields{"audit": action, "target": target}, err)
+}
+
+// AuditScript records a script run by a remote user along with its code and arguments
+func (a *WindowsAgent) AuditScript(action, shell, code string, args []string, err error) {
+	a.audit(logrus.Fields{"audit": action, "shell": shell, "script": code, "args": strings.Join(args, " ")}, err)
+}
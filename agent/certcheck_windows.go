@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+	"golang.org/x/sys/windows"
+)
+
+var certExtensions = map[string]bool{
+	".pem": true, ".crt": true, ".cer": true, ".der": true, ".pfx": true, ".p12": true,
+}
+
+// CertInfo holds the details reported for a single certificate
+type CertInfo struct {
+	Source   string   `json:"source"`
+	Subject  string   `json:"subject"`
+	Issuer   string   `json:"issuer"`
+	SANs     []string `json:"sans"`
+	NotAfter string   `json:"not_after"`
+	DaysLeft int      `json:"days_left"`
+	Status   string   `json:"status"`
+}
+
+// CertExpiryCheck scans certificate files, directories and optionally the LocalMachine\My store
+// and reports how many days each certificate has left
+func (a *WindowsAgent) CertExpiryCheck(data rmm.Check, r *resty.Client) {
+	warnDays, critDays := data.WarnDays, data.CritDays
+	if warnDays <= 0 {
+		warnDays = 30
+	}
+	if critDays <= 0 {
+		critDays = 7
+	}
+
+	certs := make([]CertInfo, 0)
+	errs := make([]string, 0)
+
+	for _, path := range data.Paths {
+		found, err := a.certsFromPath(path, data.CertPassword)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		certs = append(certs, found...)
+	}
+
+	if data.ScanCertStore || len(data.Paths) == 0 {
+		found, err := certsFromStore("MY")
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("LocalMachine\\My: %v", err))
+		}
+		certs = append(certs, found...)
+	}
+
+	status := "passing"
+	for i, c := range certs {
+		switch {
+		case c.DaysLeft <= critDays:
+			certs[i].Status = "failing"
+			status = "failing"
+		case c.DaysLeft <= warnDays:
+			certs[i].Status = "warning"
+			if status == "passing" {
+				status = "warning"
+			}
+		default:
+			certs[i].Status = "passing"
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].DaysLeft < certs[j].DaysLeft })
+
+	output := fmt.Sprintf("%d certificates found", len(certs))
+	if len(certs) > 0 {
+		output = fmt.Sprintf("%s, %s expires soonest in %d days", output, certs[0].Subject, certs[0].DaysLeft)
+	}
+
+	payload := map[string]interface{}{
+		"id":     data.CheckPK,
+		"status": status,
+		"certs":  certs,
+		"errors": errs,
+		"output": output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// certsFromPath reads a single certificate file or every certificate file in a directory
+func (a *WindowsAgent) certsFromPath(path, password string) ([]CertInfo, error) {
+	ret := make([]CertInfo, 0)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ret, err
+	}
+
+	if !fi.IsDir() {
+		return parseCertFile(path, password)
+	}
+
+	err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !certExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+		found, perr := parseCertFile(p, password)
+		if perr != nil {
+			a.Logger.Debugln("Cert:", perr)
+		}
+		ret = append(ret, found...)
+		return nil
+	})
+	return ret, err
+}
+
+// parseCertFile handles PEM bundles, DER and PFX files
+func parseCertFile(path, password string) ([]CertInfo, error) {
+	ret := make([]CertInfo, 0)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ret, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".pfx" || ext == ".p12" {
+		return pfxCerts(path, b, password)
+	}
+
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			ret = append(ret, newCertInfo(path, cert))
+		}
+	}
+
+	if len(ret) == 0 {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return ret, fmt.Errorf("%s: no certificates found", path)
+		}
+		ret = append(ret, newCertInfo(path, cert))
+	}
+	return ret, nil
+}
+
+// certsFromStore reads every certificate in a LocalMachine system store
+func certsFromStore(name string) ([]CertInfo, error) {
+	ret := make([]CertInfo, 0)
+	storeName, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return ret, err
+	}
+
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0,
+		windows.CERT_SYSTEM_STORE_LOCAL_MACHINE|windows.CERT_STORE_READONLY_FLAG, uintptr(unsafe.Pointer(storeName)))
+	if err != nil {
+		return ret, err
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	return storeCerts(store, fmt.Sprintf("LocalMachine\\%s", name)), nil
+}
+
+// pfxCerts reads the certificates in a PFX file with the windows crypto api
+// Unlike the go pkcs12 package it handles the PBES2/AES encrypted files that current windows and openssl versions export
+func pfxCerts(path string, b []byte, password string) ([]CertInfo, error) {
+	if len(b) == 0 {
+		return make([]CertInfo, 0), fmt.Errorf("%s: empty file", path)
+	}
+	pw, err := windows.UTF16PtrFromString(password)
+	if err != nil {
+		return make([]CertInfo, 0), err
+	}
+
+	blob := windows.CryptDataBlob{Size: uint32(len(b)), Data: &b[0]}
+	// the keys are never used, don't leave them behind in the key store
+	store, err := windows.PFXImportCertStore(&blob, pw, windows.PKCS12_NO_PERSIST_KEY)
+	if err != nil && password == "" {
+		// files exported without a password are protected with either an empty or a null one
+		store, err = windows.PFXImportCertStore(&blob, nil, windows.PKCS12_NO_PERSIST_KEY)
+	}
+	if err != nil {
+		return make([]CertInfo, 0), fmt.Errorf("%s: %v", path, err)
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	return storeCerts(store, path), nil
+}
+
+// storeCerts returns every certificate in an open store
+func storeCerts(store windows.Handle, source string) []CertInfo {
+	ret := make([]CertInfo, 0)
+	var (
+		ctx *windows.CertContext
+		err error
+	)
+	for {
+		ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+		if err != nil || ctx == nil {
+			break
+		}
+		encoded := (*[1 << 20]byte)(unsafe.Pointer(ctx.EncodedCert))[:ctx.Length:ctx.Length]
+		b := make([]byte, len(encoded))
+		copy(b, encoded)
+		if cert, err := x509.ParseCertificate(b); err == nil {
+			ret = append(ret, newCertInfo(source, cert))
+		}
+	}
+	return ret
+}
+
+func newCertInfo(source string, cert *x509.Certificate) CertInfo {
+	sans := make([]string, 0)
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+
+	return CertInfo{
+		Source:   source,
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		SANs:     sans,
+		NotAfter: cert.NotAfter.Format(time.RFC3339),
+		DaysLeft: int(math.Floor(time.Until(cert.NotAfter).Hours() / 24)),
+	}
+}
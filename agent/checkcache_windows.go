@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const (
+	checkCacheFile   = "checks_cache.json"
+	resultQueueFile  = "checkresults_queue.json"
+	maxQueuedResults = 1000
+)
+
+var resultQueueMu sync.Mutex
+
+type checkCache struct {
+	Checks json.RawMessage `json:"checks"`
+	Saved  int64           `json:"saved"`
+	HMAC   string          `json:"hmac"`
+}
+
+// signature is keyed with the agent token so the cache can't be edited to run arbitrary scripts
+func (a *WindowsAgent) checkCacheSignature(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(a.Token))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SaveCheckCache stores the last good check definitions on disk
+func (a *WindowsAgent) SaveCheckCache(body []byte) {
+	cache := checkCache{
+		Checks: json.RawMessage(body),
+		Saved:  time.Now().Unix(),
+		HMAC:   a.checkCacheSignature(body),
+	}
+	if err := SaveJSON(a.CheckDataFile(checkCacheFile), cache); err != nil {
+		a.Logger.Debugln("Check cache:", err)
+	}
+}
+
+// LoadCheckCache returns the cached check definitions if the signature matches
+func (a *WindowsAgent) LoadCheckCache() (rmm.AllChecks, error) {
+	data := rmm.AllChecks{}
+	cache := checkCache{}
+	if err := LoadJSON(a.CheckDataFile(checkCacheFile), &cache); err != nil {
+		return data, err
+	}
+
+	if !hmac.Equal([]byte(cache.HMAC), []byte(a.checkCacheSignature(cache.Checks))) {
+		return data, errors.New("check cache signature mismatch")
+	}
+
+	err := json.Unmarshal(cache.Checks, &data)
+	return data, err
+}
+
+// queueCheckResult saves a result that couldn't be sent so it can be sent once the server is reachable again
+func (a *WindowsAgent) queueCheckResult(payload map[string]interface{}) {
+	resultQueueMu.Lock()
+	defer resultQueueMu.Unlock()
+
+	queueFile := a.CheckDataFile(resultQueueFile)
+	queue := make([]map[string]interface{}, 0)
+	_ = LoadJSON(queueFile, &queue)
+
+	payload["queued"] = true
+	payload["queued_at"] = time.Now().Unix()
+	queue = append(queue, payload)
+	if len(queue) > maxQueuedResults {
+		queue = queue[len(queue)-maxQueuedResults:]
+	}
+
+	if err := SaveJSON(queueFile, queue); err != nil {
+		a.Logger.Debugln("Check result queue:", err)
+	}
+}
+
+// FlushCheckResults sends queued results in order, stopping at the first failure
+func (a *WindowsAgent) FlushCheckResults(r *resty.Client) {
+	resultQueueMu.Lock()
+	defer resultQueueMu.Unlock()
+
+	queueFile := a.CheckDataFile(resultQueueFile)
+	queue := make([]map[string]interface{}, 0)
+	if err := LoadJSON(queueFile, &queue); err != nil || len(queue) == 0 {
+		return
+	}
+
+	a.Logger.Debugln("Sending", len(queue), "queued check results")
+	sent := 0
+	for _, payload := range queue {
+		resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
+		if err != nil || resp.StatusCode() >= 500 {
+			break
+		}
+		sent++
+	}
+
+	if err := SaveJSON(queueFile, queue[sent:]); err != nil {
+		a.Logger.Debugln("Check result queue:", err)
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// checkDeps tracks check parents and the latest status of each check
+// so dependents of a failing check can be suppressed instead of run
+type checkDeps struct {
+	mu           sync.Mutex
+	parents      map[int]int
+	serverStatus map[int]string
+	localStatus  map[int]string
+}
+
+var deps = &checkDeps{
+	parents:      make(map[int]int),
+	serverStatus: make(map[int]string),
+	localStatus:  make(map[int]string),
+}
+
+// update replaces the known parents and the statuses last reported by the server
+func (d *checkDeps) update(checks []rmm.Check) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.parents = make(map[int]int)
+	d.serverStatus = make(map[int]string)
+	for _, c := range checks {
+		if c.ParentCheck != 0 {
+			d.parents[c.CheckPK] = c.ParentCheck
+		}
+		d.serverStatus[c.CheckPK] = c.Status
+	}
+}
+
+// setStatus records the latest status of a check, either from the agent's result or the server's response
+// An empty status clears it so the status from the last definition fetch is used
+func (d *checkDeps) setStatus(pk int, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if status == "" {
+		delete(d.localStatus, pk)
+		return
+	}
+	d.localStatus[pk] = status
+}
+
+// status prefers the latest result, falling back to the status from the last definition fetch
+func (d *checkDeps) status(pk int) string {
+	if s, ok := d.localStatus[pk]; ok {
+		return s
+	}
+	return d.serverStatus[pk]
+}
+
+// current returns the status used when evaluating dependents of pk
+func (d *checkDeps) current(pk int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status(pk)
+}
+
+// cyclic reports whether following the parents from pk leads back to it
+func (d *checkDeps) cyclic(pk int) bool {
+	seen := map[int]bool{pk: true}
+	for p, ok := d.parents[pk]; ok; p, ok = d.parents[p] {
+		if seen[p] {
+			return true
+		}
+		seen[p] = true
+	}
+	return false
+}
+
+// suppressedBy returns the parent that is failing or itself suppressed, or 0 if the check should run
+func (d *checkDeps) suppressedBy(check rmm.Check) int {
+	if check.ParentCheck == 0 {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cyclic(check.CheckPK) {
+		return 0
+	}
+	switch d.status(check.ParentCheck) {
+	case "failing", "suppressed":
+		return check.ParentCheck
+	}
+	return 0
+}
+
+// depth is the number of parents above the check, cycles are treated as having none
+func (d *checkDeps) depth(pk int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cyclic(pk) {
+		return 0
+	}
+	n := 0
+	for p, ok := d.parents[pk]; ok; p, ok = d.parents[p] {
+		n++
+	}
+	return n
+}
+
+// SendSuppressed reports a check that wasn't run because its parent is failing
+func (a *WindowsAgent) SendSuppressed(check rmm.Check, parent int, r *resty.Client) {
+	a.Logger.Debugln("Check", check.CheckPK, "suppressed by parent", parent)
+	payload := map[string]interface{}{
+		"id":           check.CheckPK,
+		"status":       "suppressed",
+		"parent_check": parent,
+		"output":       fmt.Sprintf("Not run, parent check %d is failing", parent),
+	}
+	a.SendCheckResult(payload, nil, r)
+}
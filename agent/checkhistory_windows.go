@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	checkHistorySize = 21
+	flapHighPercent  = 50.0
+	flapLowPercent   = 25.0
+)
+
+var checkHistoryMu sync.Mutex
+
+type checkHistoryEntry struct {
+	Time   int64  `json:"time"`
+	Status string `json:"status"`
+}
+
+type checkHistory struct {
+	Results  []checkHistoryEntry `json:"results"`
+	Flapping bool                `json:"flapping"`
+}
+
+// addCheckHistory appends the status to the check's ring buffer of recent results, an empty status only re-evaluates it
+// Returns whether the check is flapping and its weighted state change percentage, like nagios a check
+// starts flapping above the high threshold and only stops once it drops below the low one
+func (a *WindowsAgent) addCheckHistory(pk int, status string) (bool, float64) {
+	checkHistoryMu.Lock()
+	defer checkHistoryMu.Unlock()
+
+	historyFile := a.CheckDataFile(fmt.Sprintf("history_%d.json", pk))
+	h := checkHistory{}
+	_ = LoadJSON(historyFile, &h)
+
+	// suppressed results say nothing about the check itself
+	if status != "" && status != "suppressed" {
+		h.Results = append(h.Results, checkHistoryEntry{Time: time.Now().Unix(), Status: status})
+		if len(h.Results) > checkHistorySize {
+			h.Results = h.Results[len(h.Results)-checkHistorySize:]
+		}
+	}
+
+	percent := stateChangePercent(h.Results)
+	if percent >= flapHighPercent {
+		h.Flapping = true
+	} else if percent < flapLowPercent {
+		h.Flapping = false
+	}
+
+	if status != "" {
+		if err := SaveJSON(historyFile, h); err != nil {
+			a.Logger.Debugln("Check history:", err)
+		}
+	}
+	return h.Flapping, percent
+}
+
+// stateChangePercent weights recent state changes more heavily, from 0.8 for the oldest to 1.2 for the newest
+func stateChangePercent(results []checkHistoryEntry) float64 {
+	transitions := len(results) - 1
+	if transitions < 1 {
+		return 0
+	}
+
+	var total float64
+	for i := 1; i < len(results); i++ {
+		if results[i].Status == results[i-1].Status {
+			continue
+		}
+		weight := 1.0
+		if transitions > 1 {
+			weight = 0.8 + 0.4*float64(i-1)/float64(transitions-1)
+		}
+		total += weight
+	}
+	return math.Round(total/float64(transitions)*1000) / 10
+}
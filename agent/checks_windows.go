@@ -6,53 +6,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	ps "github.com/elastic/go-sysinfo"
 	"github.com/go-resty/resty/v2"
 	"github.com/shirou/gopsutil/v3/disk"
 	rmm "github.com/wh1te909/rmmagent/shared"
 )
 
-func (a *WindowsAgent) CheckRunner() {
-	a.Logger.Infoln("Checkrunner service started.")
-	sleepDelay := randRange(14, 22)
-	a.Logger.Debugf("Sleeping for %v seconds", sleepDelay)
-	time.Sleep(time.Duration(sleepDelay) * time.Second)
-	for {
-		interval, err := a.GetCheckInterval()
-		if err == nil && !a.ChecksRunning() {
-			_, err = CMD(a.EXE, []string{"-m", "checkrunner"}, 600, false)
-			if err != nil {
-				a.Logger.Errorln("Checkrunner RunChecks", err)
-			}
-		}
-		a.Logger.Debugln("Checkrunner sleeping for", interval)
-		time.Sleep(time.Duration(interval) * time.Second)
-	}
-}
-
-func (a *WindowsAgent) GetCheckInterval() (int, error) {
-	r, err := a.rClient.R().SetResult(&rmm.CheckInfo{}).Get(fmt.Sprintf("/api/v3/%s/checkinterval/", a.AgentID))
-	if err != nil {
-		a.Logger.Debugln(err)
-		return 120, err
-	}
-	if r.IsError() {
-		a.Logger.Debugln("Checkinterval response code:", r.StatusCode())
-		return 120, fmt.Errorf("checkinterval response code: %v", r.StatusCode())
-	}
-	interval := r.Result().(*rmm.CheckInfo).Interval
-	return interval, nil
-}
-
-func (a *WindowsAgent) RunChecks(force bool) error {
+// GetChecks fetches the agent's check definitions
+func (a *WindowsAgent) GetChecks(force bool) (rmm.AllChecks, error) {
 	data := rmm.AllChecks{}
 	var url string
 	if force {
@@ -61,67 +29,84 @@ func (a *WindowsAgent) RunChecks(force bool) error {
 		url = fmt.Sprintf("/api/v3/%s/checkrunner/", a.AgentID)
 	}
 	r, err := a.rClient.R().Get(url)
-	if err != nil {
+	if err != nil || r.StatusCode() >= 500 {
+		// server unreachable, keep monitoring with the last known checks
+		if err == nil {
+			err = fmt.Errorf("checkrunner response code: %v", r.StatusCode())
+		}
 		a.Logger.Debugln(err)
-		return err
+		cached, cerr := a.LoadCheckCache()
+		if cerr != nil {
+			a.Logger.Debugln("Check cache:", cerr)
+			return data, err
+		}
+		a.Logger.Debugln("Running checks from cache")
+		deps.update(cached.Checks)
+		return cached, nil
 	}
 
 	if r.IsError() {
 		a.Logger.Debugln("Checkrunner response code:", r.StatusCode())
-		return nil
+		return data, fmt.Errorf("checkrunner response code: %v", r.StatusCode())
 	}
 
 	if err := json.Unmarshal(r.Body(), &data); err != nil {
 		a.Logger.Debugln(err)
+		return data, err
+	}
+
+	a.SaveCheckCache(r.Body())
+	a.FlushCheckResults(a.rClient)
+	deps.update(data.Checks)
+	return data, nil
+}
+
+// RunChecks runs every check once and waits for them to finish
+// Checks run in waves by dependency depth so parents report before their dependents are evaluated
+func (a *WindowsAgent) RunChecks(force bool) error {
+	data, err := a.GetChecks(force)
+	if err != nil {
 		return err
 	}
 
+	waves := make(map[int][]rmm.Check)
+	maxDepth := 0
+	for _, check := range data.Checks {
+		d := deps.depth(check.CheckPK)
+		waves[d] = append(waves[d], check)
+		if d > maxDepth {
+			maxDepth = d
+		}
+	}
+
+	for d := 0; d <= maxDepth; d++ {
+		if len(waves[d]) > 0 {
+			a.runCheckWave(waves[d])
+		}
+	}
+	return nil
+}
+
+func (a *WindowsAgent) runCheckWave(checks []rmm.Check) {
 	var wg sync.WaitGroup
 	eventLogChecks := make([]rmm.Check, 0)
 	winServiceChecks := make([]rmm.Check, 0)
 
-	for _, check := range data.Checks {
+	for _, check := range checks {
 		switch check.CheckType {
-		case "diskspace":
-			wg.Add(1)
-			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
-				defer wg.Done()
-				time.Sleep(time.Duration(randRange(300, 950)) * time.Millisecond)
-				a.DiskCheck(c, r)
-			}(check, &wg, a.rClient)
-		case "cpuload":
-			wg.Add(1)
-			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
-				defer wg.Done()
-				a.CPULoadCheck(c, r)
-			}(check, &wg, a.rClient)
-		case "memory":
-			wg.Add(1)
-			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
-				defer wg.Done()
-				time.Sleep(time.Duration(randRange(300, 950)) * time.Millisecond)
-				a.MemCheck(c, r)
-			}(check, &wg, a.rClient)
-		case "ping":
-			wg.Add(1)
-			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
-				defer wg.Done()
-				time.Sleep(time.Duration(randRange(300, 950)) * time.Millisecond)
-				a.PingCheck(c, r)
-			}(check, &wg, a.rClient)
-		case "script":
-			wg.Add(1)
-			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
-				defer wg.Done()
-				time.Sleep(time.Duration(randRange(300, 950)) * time.Millisecond)
-				a.ScriptCheck(c, r)
-			}(check, &wg, a.rClient)
 		case "winsvc":
 			winServiceChecks = append(winServiceChecks, check)
 		case "eventlog":
 			eventLogChecks = append(eventLogChecks, check)
 		default:
-			continue
+			wg.Add(1)
+			go func(c rmm.Check, wg *sync.WaitGroup, r *resty.Client) {
+				defer wg.Done()
+				if c.CheckType != "cpuload" {
+					time.Sleep(time.Duration(randRange(300, 950)) * time.Millisecond)
+				}
+				a.RunCheck(c, r)
+			}(check, &wg, a.rClient)
 		}
 	}
 
@@ -130,7 +115,7 @@ func (a *WindowsAgent) RunChecks(force bool) error {
 		go func(wg *sync.WaitGroup, r *resty.Client) {
 			for _, winSvcCheck := range winServiceChecks {
 				defer wg.Done()
-				a.WinSvcCheck(winSvcCheck, r)
+				a.RunCheck(winSvcCheck, r)
 			}
 		}(&wg, a.rClient)
 	}
@@ -140,12 +125,67 @@ func (a *WindowsAgent) RunChecks(force bool) error {
 		go func(wg *sync.WaitGroup, r *resty.Client) {
 			for _, evtCheck := range eventLogChecks {
 				defer wg.Done()
-				a.EventLogCheck(evtCheck, r)
+				a.RunCheck(evtCheck, r)
 			}
 		}(&wg, a.rClient)
 	}
 	wg.Wait()
-	return nil
+}
+
+// RunCheck runs a single check and sends the result
+func (a *WindowsAgent) RunCheck(check rmm.Check, r *resty.Client) {
+	start := time.Now()
+	defer func() { metrics.observeCheck(check.CheckPK, check.CheckType, time.Since(start)) }()
+
+	if parent := deps.suppressedBy(check); parent != 0 {
+		a.SendSuppressed(check, parent, r)
+		return
+	}
+
+	switch check.CheckType {
+	case "diskspace":
+		a.DiskCheck(check, r)
+	case "cpuload":
+		a.CPULoadCheck(check, r)
+	case "memory":
+		a.MemCheck(check, r)
+	case "ping":
+		a.PingCheck(check, r)
+	case "script":
+		a.ScriptCheck(check, r)
+	case "winsvc":
+		a.WinSvcCheck(check, r)
+	case "eventlog":
+		a.EventLogCheck(check, r)
+	case "fileintegrity":
+		a.FileIntegrityCheck(check, r)
+	case "tcp":
+		a.TCPCheck(check, r)
+	case "http":
+		a.HTTPCheck(check, r)
+	case "certexpiry":
+		a.CertExpiryCheck(check, r)
+	case "process":
+		a.ProcessCheck(check, r)
+	case "logfile":
+		a.LogFileCheck(check, r)
+	case "dns":
+		a.DNSCheck(check, r)
+	case "netif":
+		a.NetIfCheck(check, r)
+	case "sensors":
+		a.SensorsCheck(check, r)
+	case "fileage":
+		a.FileAgeCheck(check, r)
+	case "dirsize":
+		a.DirSizeCheck(check, r)
+	case "nagios":
+		a.NagiosCheck(check, r)
+	case "smart":
+		a.SmartCheck(check, r)
+	default:
+		a.Logger.Debugln("Unknown check type:", check.CheckType)
+	}
 }
 
 func (a *WindowsAgent) RunScript(code string, shell string, args []string, timeout int) (stdout, stderr string, exitcode int, e error) {
@@ -157,11 +197,7 @@ func (a *WindowsAgent) RunScript(code string, shell string, args []string, timeo
 		a.CreateTRMMTempDir()
 	}
 
-	const defaultExitCode = 1
-
 	var (
-		outb    bytes.Buffer
-		errb    bytes.Buffer
 		exe     string
 		ext     string
 		cmdArgs []string
@@ -207,6 +243,20 @@ func (a *WindowsAgent) RunScript(code string, shell string, args []string, timeo
 		cmdArgs = append(cmdArgs, args...)
 	}
 
+	stdout, stderr, exitcode, e = a.runKillTree(exe, cmdArgs, timeout)
+	metrics.countScript(shell, exitcode)
+	return
+}
+
+// runKillTree runs exe and kills it along with its children if it runs past the timeout
+func (a *WindowsAgent) runKillTree(exe string, cmdArgs []string, timeout int) (stdout, stderr string, exitcode int, e error) {
+	const defaultExitCode = 1
+
+	var (
+		outb bytes.Buffer
+		errb bytes.Buffer
+	)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	defer cancel()
 
@@ -237,7 +287,7 @@ func (a *WindowsAgent) RunScript(code string, shell string, args []string, timeo
 	if timedOut {
 		stdout = outb.String()
 		stderr = fmt.Sprintf("%s\nScript timed out after %d seconds", errb.String(), timeout)
-		exitcode = 98
+		exitcode = timedOutExitCode
 		a.Logger.Debugln("Script check timeout:", ctx.Err())
 	} else {
 		stdout = outb.String()
@@ -279,13 +329,7 @@ func (a *WindowsAgent) ScriptCheck(data rmm.Check, r *resty.Client) {
 		"runtime": time.Since(start).Seconds(),
 	}
 
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
-	}
-
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
+	a.SendCheckResult(payload, data.AssignedTasks, r)
 }
 
 // DiskCheck checks disk usage
@@ -296,63 +340,39 @@ func (a *WindowsAgent) DiskCheck(data rmm.Check, r *resty.Client) {
 	if err != nil {
 		a.Logger.Debugln("Disk", data.Disk, err)
 		payload = map[string]interface{}{"id": data.CheckPK, "exists": false}
-		if _, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/"); err != nil {
-			a.Logger.Debugln(err)
-		}
+		a.SendCheckResult(payload, nil, r)
 		return
 	}
 
+	daysToFull := a.diskDaysToFull(data.CheckPK, usage)
+
 	payload = map[string]interface{}{
 		"id":           data.CheckPK,
 		"exists":       true,
 		"percent_used": usage.UsedPercent,
 		"total":        usage.Total,
 		"free":         usage.Free,
+		"days_to_full": daysToFull,
 	}
-
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
-	}
-
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
-}
-
-// CPULoadCheck checks avg cpu load
-func (a *WindowsAgent) CPULoadCheck(data rmm.Check, r *resty.Client) {
-	payload := map[string]interface{}{
-		"id":      data.CheckPK,
-		"percent": a.GetCPULoadAvg(),
-	}
-
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
-	}
-
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
-}
-
-// MemCheck checks mem percentage
-func (a *WindowsAgent) MemCheck(data rmm.Check, r *resty.Client) {
-	host, _ := ps.Host()
-	mem, _ := host.Memory()
-	percent := (float64(mem.Used) / float64(mem.Total)) * 100
-
-	payload := map[string]interface{}{
-		"id":      data.CheckPK,
-		"percent": int(math.Round(percent)),
+	// ntfs has no inodes, the mft's file records are the equivalent
+	if mft, err := ntfsMFTUsage(data.Disk); err == nil {
+		payload["mft_records"] = mft.Records
+		payload["mft_total"] = mft.Total
+		payload["mft_percent"] = mft.Percent
+	} else {
+		a.Logger.Debugln("Disk MFT", data.Disk, err)
 	}
 
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
+	if daysToFull >= 0 {
+		switch {
+		case data.FullFailDays > 0 && daysToFull < data.FullFailDays:
+			payload["status"] = "failing"
+		case data.FullWarnDays > 0 && daysToFull < data.FullWarnDays:
+			payload["status"] = "warning"
+		}
 	}
 
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
+	a.SendCheckResult(payload, data.AssignedTasks, r)
 }
 
 func (a *WindowsAgent) EventLogCheck(data rmm.Check, r *resty.Client) {
@@ -362,59 +382,78 @@ func (a *WindowsAgent) EventLogCheck(data rmm.Check, r *resty.Client) {
 		"log": evtLog,
 	}
 
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
-	}
-
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
+	a.SendCheckResult(payload, data.AssignedTasks, r)
 }
 
 func (a *WindowsAgent) PingCheck(data rmm.Check, r *resty.Client) {
-	cmdArgs := []string{data.IP}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(90)*time.Second)
-	defer cancel()
+	count := data.PingCount
+	if count <= 0 {
+		count = 4
+	}
+	interval := time.Duration(data.PingIntervalMS) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	timeout := time.Duration(data.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
 
 	var (
-		outb   bytes.Buffer
-		errb   bytes.Buffer
-		hasOut bool
-		hasErr bool
-		output string
+		status   = "passing"
+		failures = make([]string, 0)
+		warnings = make([]string, 0)
 	)
-	cmd := exec.CommandContext(ctx, "ping", cmdArgs...)
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
 
-	cmdErr := cmd.Run()
+	stats, err := NativePing(data.IP, count, interval, timeout)
+	if err != nil {
+		a.Logger.Debugln("Ping check:", err)
+		failures = append(failures, err.Error())
+	}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		a.Logger.Debugln("Ping check:", ctx.Err())
-		hasErr = true
-		output = fmt.Sprintf("Ping check %s timed out", data.IP)
-	} else if cmdErr != nil || errb.String() != "" {
-		hasErr = true
-		output = fmt.Sprintf("%s\n%s", outb.String(), errb.String())
-	} else {
-		hasOut = true
-		output = outb.String()
+	if stats.Received > 0 {
+		// 100% loss is already a failure above
+		switch {
+		case data.LossFail > 0 && stats.Loss >= data.LossFail:
+			failures = append(failures, fmt.Sprintf("packet loss %.0f%% exceeds %.0f%%", stats.Loss, data.LossFail))
+		case data.LossWarn > 0 && stats.Loss >= data.LossWarn:
+			warnings = append(warnings, fmt.Sprintf("packet loss %.0f%% exceeds %.0f%%", stats.Loss, data.LossWarn))
+		}
+		switch {
+		case data.RTTFailMS > 0 && stats.AvgMS >= data.RTTFailMS:
+			failures = append(failures, fmt.Sprintf("average rtt %.1f ms exceeds %.0f ms", stats.AvgMS, data.RTTFailMS))
+		case data.RTTWarnMS > 0 && stats.AvgMS >= data.RTTWarnMS:
+			warnings = append(warnings, fmt.Sprintf("average rtt %.1f ms exceeds %.0f ms", stats.AvgMS, data.RTTWarnMS))
+		}
 	}
 
-	payload := map[string]interface{}{
-		"id":         data.CheckPK,
-		"has_stdout": hasOut,
-		"has_stderr": hasErr,
-		"output":     output,
+	if len(failures) > 0 {
+		status = "failing"
+	} else if len(warnings) > 0 {
+		status = "warning"
 	}
 
-	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
-		return
+	output := fmt.Sprintf("Ping %s (%s): %d sent, %d received, %.0f%% loss, rtt min/avg/max = %.1f/%.1f/%.1f ms",
+		data.IP, stats.Addr, stats.Sent, stats.Received, stats.Loss, stats.MinMS, stats.AvgMS, stats.MaxMS)
+	if msgs := append(failures, warnings...); len(msgs) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(msgs, ", "))
 	}
 
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
+	payload := map[string]interface{}{
+		"id":           data.CheckPK,
+		"status":       status,
+		"has_stdout":   status != "failing",
+		"has_stderr":   status == "failing",
+		"sent":         stats.Sent,
+		"received":     stats.Received,
+		"loss_percent": stats.Loss,
+		"min_ms":       stats.MinMS,
+		"avg_ms":       stats.AvgMS,
+		"max_ms":       stats.MaxMS,
+		"output":       output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
 }
 
 func (a *WindowsAgent) WinSvcCheck(data rmm.Check, r *resty.Client) {
@@ -434,13 +473,48 @@ func (a *WindowsAgent) WinSvcCheck(data rmm.Check, r *resty.Client) {
 		"status": status,
 	}
 
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// SendCheckResult sends a check result to the server and runs any assigned tasks if the check failed
+// Results that can't be delivered are queued and sent once the server is reachable again
+func (a *WindowsAgent) SendCheckResult(payload map[string]interface{}, tasks []rmm.AssignedTask, r *resty.Client) {
+	pk, hasPK := payload["id"].(int)
+	status, _ := payload["status"].(string)
+	if hasPK {
+		if status != "" {
+			deps.setStatus(pk, status)
+			metrics.setCheckStatus(pk, status)
+		}
+		flapping, percent := a.addCheckHistory(pk, status)
+		payload["flapping"] = flapping
+		payload["state_change_percent"] = percent
+	}
+
 	resp, err := r.R().SetBody(payload).Patch("/api/v3/checkrunner/")
-	if err != nil {
-		a.Logger.Debugln(err)
+	if err != nil || resp.StatusCode() >= 500 {
+		if err != nil {
+			a.Logger.Debugln(err)
+		} else {
+			a.Logger.Debugln("Checkrunner response code:", resp.StatusCode())
+		}
+		a.queueCheckResult(payload)
 		return
 	}
 
-	a.handleAssignedTasks(resp.String(), data.AssignedTasks)
+	// checks the server evaluates only get their status back in the response, so their flapping flag lags one run behind
+	if hasPK && status == "" {
+		switch serverStatus := DjangoStringResp(resp.String()); serverStatus {
+		case "passing", "warning", "failing":
+			deps.setStatus(pk, serverStatus)
+			a.addCheckHistory(pk, serverStatus)
+			metrics.setCheckStatus(pk, serverStatus)
+		default:
+			deps.setStatus(pk, "")
+		}
+	}
+
+	a.handleAssignedTasks(resp.String(), tasks)
 }
 
 func (a *WindowsAgent) handleAssignedTasks(status string, tasks []rmm.AssignedTask) {
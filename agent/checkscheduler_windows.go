@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	rmm "github.com/wh1te909/rmmagent/shared"
+	"golang.org/x/sys/windows"
+)
+
+// runChecksEvent lets other processes ask the scheduler in the rpc service to run every check
+const runChecksEvent = `Global\TacticalRMMRunChecks`
+
+const (
+	defaultCheckInterval = 120
+	minCheckInterval     = 15
+	minRefreshInterval   = 30
+)
+
+// CheckScheduler runs each check on its own interval inside the long running service
+// and periodically refreshes the check definitions from the server
+type CheckScheduler struct {
+	a       *WindowsAgent
+	trigger chan struct{}
+
+	mu       sync.Mutex
+	checks   map[int]*scheduledCheck
+	interval int
+}
+
+type scheduledCheck struct {
+	mu      sync.Mutex
+	check   rmm.Check
+	def     string
+	running uint32
+	stop    chan struct{}
+	reset   chan struct{}
+	kick    chan struct{}
+}
+
+// NewCheckScheduler creates a scheduler, call Run to start it
+func (a *WindowsAgent) NewCheckScheduler() *CheckScheduler {
+	return &CheckScheduler{
+		a:        a,
+		trigger:  make(chan struct{}, 1),
+		checks:   make(map[int]*scheduledCheck),
+		interval: defaultCheckInterval,
+	}
+}
+
+// Trigger asks the scheduler to refresh the definitions and run every check now
+// Returns false if a previous trigger hasn't been picked up yet
+func (s *CheckScheduler) Trigger() bool {
+	select {
+	case s.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run loads the check definitions and keeps them in sync with the server
+func (s *CheckScheduler) Run() {
+	s.a.Logger.Infoln("Check scheduler started")
+	go s.listenTrigger()
+	sleepDelay := randRange(14, 22)
+	s.a.Logger.Debugf("Sleeping for %v seconds", sleepDelay)
+	time.Sleep(time.Duration(sleepDelay) * time.Second)
+
+	s.refresh(false)
+	timer := time.NewTimer(s.refreshInterval())
+	for {
+		select {
+		case <-s.trigger:
+			s.refresh(true)
+			s.runAll()
+		case <-timer.C:
+			s.refresh(false)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.refreshInterval())
+	}
+}
+
+// listenTrigger triggers a run whenever the named event is set by -m runchecks or -m checkrunner
+func (s *CheckScheduler) listenTrigger() {
+	// only system and administrators may trigger a run
+	sd, err := windows.SecurityDescriptorFromString("D:(A;;GA;;;SY)(A;;GA;;;BA)")
+	if err != nil {
+		s.a.Logger.Errorln("Check scheduler trigger:", err)
+		return
+	}
+	sa := &windows.SecurityAttributes{Length: uint32(unsafe.Sizeof(windows.SecurityAttributes{})), SecurityDescriptor: sd}
+	name, _ := windows.UTF16PtrFromString(runChecksEvent)
+	event, err := windows.CreateEvent(sa, 0, 0, name)
+	if err != nil {
+		s.a.Logger.Errorln("Check scheduler trigger:", err)
+		return
+	}
+	defer windows.CloseHandle(event)
+
+	for {
+		if _, err := windows.WaitForSingleObject(event, windows.INFINITE); err != nil {
+			s.a.Logger.Errorln("Check scheduler trigger:", err)
+			return
+		}
+		s.Trigger()
+	}
+}
+
+// TriggerChecks asks the check scheduler in the rpc service to run every check
+// Returns false if the service isn't running
+func TriggerChecks() (bool, error) {
+	name, _ := windows.UTF16PtrFromString(runChecksEvent)
+	event, err := windows.OpenEvent(windows.EVENT_MODIFY_STATE, false, name)
+	if err == windows.ERROR_FILE_NOT_FOUND {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer windows.CloseHandle(event)
+	return true, windows.SetEvent(event)
+}
+
+// TriggerOrRunChecks runs the checks through the scheduler so the cli can't run them alongside it,
+// only running them in this process if the rpc service is stopped
+func (a *WindowsAgent) TriggerOrRunChecks(force bool) {
+	triggered, err := TriggerChecks()
+	if err != nil {
+		a.Logger.Errorln("Run checks:", err)
+		return
+	}
+	if triggered {
+		a.Logger.Infoln("Checks triggered in the rpc service")
+		return
+	}
+	a.RunChecks(force)
+}
+
+func (s *CheckScheduler) refreshInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := s.interval
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// refresh fetches the definitions, starting new checks, stopping removed ones and updating changed ones in place
+func (s *CheckScheduler) refresh(force bool) {
+	data, err := s.a.GetChecks(force)
+	if err != nil {
+		s.a.Logger.Debugln("Check scheduler refresh:", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data.Interval > 0 {
+		s.interval = data.Interval
+	}
+
+	seen := make(map[int]bool)
+	for _, c := range data.Checks {
+		seen[c.CheckPK] = true
+		b, _ := json.Marshal(c)
+		def := string(b)
+
+		sc, ok := s.checks[c.CheckPK]
+		if !ok {
+			sc = &scheduledCheck{
+				check: c,
+				def:   def,
+				stop:  make(chan struct{}),
+				reset: make(chan struct{}, 1),
+				kick:  make(chan struct{}, 1),
+			}
+			s.checks[c.CheckPK] = sc
+			go s.loop(sc)
+			continue
+		}
+
+		sc.mu.Lock()
+		changed := sc.def != def
+		if changed {
+			sc.check = c
+			sc.def = def
+		}
+		sc.mu.Unlock()
+		if changed {
+			s.a.Logger.Debugln("Check definition changed:", c.CheckPK)
+			select {
+			case sc.reset <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	for pk, sc := range s.checks {
+		if !seen[pk] {
+			close(sc.stop)
+			delete(s.checks, pk)
+		}
+	}
+}
+
+func (s *CheckScheduler) runAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.checks {
+		select {
+		case sc.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *CheckScheduler) loop(sc *scheduledCheck) {
+	// spread the first runs out over the interval so checks don't all fire at once
+	first := s.nextDelay(sc)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(first))))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-sc.stop:
+			return
+		case <-sc.kick:
+			s.run(sc)
+		case <-sc.reset:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.nextDelay(sc))
+		case <-timer.C:
+			s.run(sc)
+			timer.Reset(s.nextDelay(sc))
+		}
+	}
+}
+
+// nextDelay returns the check's interval with up to 10% jitter
+func (s *CheckScheduler) nextDelay(sc *scheduledCheck) time.Duration {
+	sc.mu.Lock()
+	interval := sc.check.RunInterval
+	sc.mu.Unlock()
+
+	if interval <= 0 {
+		s.mu.Lock()
+		interval = s.interval
+		s.mu.Unlock()
+	}
+	if interval < minCheckInterval {
+		interval = minCheckInterval
+	}
+
+	base := time.Duration(interval) * time.Second
+	jitter := time.Duration(rand.Int63n(int64(base/10) + 1))
+	if rand.Intn(2) == 0 {
+		return base - jitter
+	}
+	return base + jitter
+}
+
+// run starts the check unless the previous run is still going
+func (s *CheckScheduler) run(sc *scheduledCheck) {
+	if !atomic.CompareAndSwapUint32(&sc.running, 0, 1) {
+		s.a.Logger.Debugln("Check still running, skipping:", sc.check.CheckPK)
+		return
+	}
+
+	sc.mu.Lock()
+	c := sc.check
+	sc.mu.Unlock()
+
+	go func() {
+		defer atomic.StoreUint32(&sc.running, 0)
+		before := deps.current(c.CheckPK)
+		s.a.RunCheck(c, s.a.rClient)
+		if deps.current(c.CheckPK) != before {
+			s.kickDependents(c.CheckPK)
+		}
+	}()
+}
+
+// kickDependents re-runs the checks depending on pk so suppression follows the parent's status straight away
+func (s *CheckScheduler) kickDependents(pk int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.checks {
+		sc.mu.Lock()
+		parent := sc.check.ParentCheck
+		sc.mu.Unlock()
+		if parent != pk {
+			continue
+		}
+		select {
+		case sc.kick <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+	gops "github.com/shirou/gopsutil/v3/process"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const (
+	defaultCPUSampleSeconds = 10
+	maxCPUSampleSeconds     = 300
+	topProcessCount         = 5
+)
+
+// TopProcess is one of the heaviest processes reported with a check result
+type TopProcess struct {
+	Pid      int32   `json:"pid"`
+	Name     string  `json:"name"`
+	Username string  `json:"username"`
+	CPU      float64 `json:"cpu_percent"`
+	RSS      uint64  `json:"rss"`
+}
+
+// CPULoadCheck samples cpu usage over a window and reports per core usage, load averages and the top processes
+func (a *WindowsAgent) CPULoadCheck(data rmm.Check, r *resty.Client) {
+	window := data.SampleSeconds
+	if window <= 0 {
+		window = defaultCPUSampleSeconds
+	} else if window > maxCPUSampleSeconds {
+		window = maxCPUSampleSeconds
+	}
+
+	procs, err := gops.Processes()
+	if err != nil {
+		a.Logger.Debugln("CPU check:", err)
+	}
+	// the first call only primes the counters, the second returns usage over the window
+	for _, p := range procs {
+		p.Percent(0)
+	}
+
+	// the load average sampler starts on the first call, start it now so it has the window to warm up
+	_, _ = load.Avg()
+
+	before, err1 := cpu.Times(false)
+	beforeCores, err2 := cpu.Times(true)
+	time.Sleep(time.Duration(window) * time.Second)
+	after, err3 := cpu.Times(false)
+	afterCores, err4 := cpu.Times(true)
+
+	var (
+		percent float64
+		cores   = make([]float64, 0)
+	)
+	if err1 == nil && err3 == nil && len(before) > 0 && len(after) > 0 {
+		percent = cpuUsage(before[0], after[0])
+	} else {
+		a.Logger.Debugln("CPU check:", err1, err3)
+	}
+	if err2 == nil && err4 == nil && len(beforeCores) == len(afterCores) {
+		for i := range beforeCores {
+			usage := cpuUsage(beforeCores[i], afterCores[i])
+			cores = append(cores, math.Round(usage*10)/10)
+		}
+	}
+
+	// on windows this is an estimate from the processor queue length, it warms up while the service runs
+	loadAvg := []float64{0, 0, 0}
+	if avg, err := load.Avg(); err == nil {
+		loadAvg = []float64{avg.Load1, avg.Load5, avg.Load15}
+	}
+
+	top := topProcesses(procs, func(p TopProcess) float64 { return p.CPU })
+
+	payload := map[string]interface{}{
+		"id":             data.CheckPK,
+		"percent":        int(math.Round(percent)),
+		"sample_seconds": window,
+		"cores":          cores,
+		"load_avg":       loadAvg,
+		"top_processes":  top,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// cpuUsage returns the busy percentage between two samples
+func cpuUsage(t1, t2 cpu.TimesStat) float64 {
+	total := t2.Total() - t1.Total()
+	if total <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(100, (total-(t2.Idle-t1.Idle))/total*100))
+}
+
+// topProcesses returns the processes with the highest value of key, cpu usage must have been primed with Percent(0)
+func topProcesses(procs []*gops.Process, key func(TopProcess) float64) []TopProcess {
+	type sampled struct {
+		p    *gops.Process
+		info TopProcess
+	}
+	all := make([]sampled, 0, len(procs))
+	for _, p := range procs {
+		cpuPercent, err := p.Percent(0)
+		if err != nil {
+			// process exited during the sample
+			continue
+		}
+		var rss uint64
+		if mem, err := p.MemoryInfo(); err == nil {
+			rss = mem.RSS
+		}
+		all = append(all, sampled{p, TopProcess{Pid: p.Pid, CPU: math.Round(cpuPercent*10) / 10, RSS: rss}})
+	}
+
+	sort.Slice(all, func(i, j int) bool { return key(all[i].info) > key(all[j].info) })
+	if len(all) > topProcessCount {
+		all = all[:topProcessCount]
+	}
+
+	// only look up names for the few that are reported
+	ret := make([]TopProcess, 0, len(all))
+	for _, s := range all {
+		s.info.Name, _ = s.p.Name()
+		s.info.Username, _ = s.p.Username()
+		ret = append(ret, s.info)
+	}
+	return ret
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const largestSubdirs = 10
+
+var errWalkTimeout = errors.New("walk timed out")
+
+// SubdirSize is the total size of one of the directory's immediate subdirectories
+type SubdirSize struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+type dirSizeState struct {
+	Size  int64 `json:"size"`
+	Files int64 `json:"files"`
+	Time  int64 `json:"time"`
+}
+
+// DirSizeCheck walks a directory up to a depth and time limit and checks its size, file count and growth
+func (a *WindowsAgent) DirSizeCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 60
+	}
+	root := filepath.Clean(data.Directory)
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	var (
+		total   int64
+		files   int64
+		partial bool
+		subdirs = make(map[string]*SubdirSize)
+	)
+
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// unreadable entries are skipped rather than failing the whole walk
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errWalkTimeout
+		}
+		if fi.IsDir() {
+			if path != root && data.MaxDepth > 0 {
+				rel, _ := filepath.Rel(root, path)
+				if strings.Count(rel, string(os.PathSeparator))+1 > data.MaxDepth {
+					partial = true
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		total += fi.Size()
+		files++
+
+		rel, err := filepath.Rel(root, path)
+		if err == nil && strings.Contains(rel, string(os.PathSeparator)) {
+			child := filepath.Join(root, strings.SplitN(rel, string(os.PathSeparator), 2)[0])
+			if _, ok := subdirs[child]; !ok {
+				subdirs[child] = &SubdirSize{Path: child}
+			}
+			subdirs[child].Size += fi.Size()
+			subdirs[child].Files++
+		}
+		return nil
+	})
+
+	if errors.Is(err, errWalkTimeout) {
+		partial = true
+	} else if err != nil {
+		payload := map[string]interface{}{
+			"id":     data.CheckPK,
+			"status": "failing",
+			"output": err.Error(),
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+		return
+	}
+
+	largest := make([]SubdirSize, 0, len(subdirs))
+	for _, s := range subdirs {
+		largest = append(largest, *s)
+	}
+	sort.Slice(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+	if len(largest) > largestSubdirs {
+		largest = largest[:largestSubdirs]
+	}
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+		growth   int64
+	)
+	if data.MaxSizeMB > 0 && total > data.MaxSizeMB*1024*1024 {
+		failures = append(failures, fmt.Sprintf("size %s exceeds %d MB", ByteCountSI(uint64(total)), data.MaxSizeMB))
+	}
+	if data.MaxFiles > 0 && files > data.MaxFiles {
+		failures = append(failures, fmt.Sprintf("%d files exceeds %d", files, data.MaxFiles))
+	}
+
+	// a walk that was cut short isn't comparable so growth is only tracked for complete runs
+	if !errors.Is(err, errWalkTimeout) {
+		stateFile := a.CheckDataFile(fmt.Sprintf("dirsize_%d.json", data.CheckPK))
+		prev := dirSizeState{}
+		if LoadJSON(stateFile, &prev) == nil {
+			growth = total - prev.Size
+			if data.MaxGrowthMB > 0 && growth > data.MaxGrowthMB*1024*1024 {
+				failures = append(failures, fmt.Sprintf("grew %s since last run, max %d MB", ByteCountSI(uint64(growth)), data.MaxGrowthMB))
+			}
+		}
+		if err := SaveJSON(stateFile, dirSizeState{Size: total, Files: files, Time: time.Now().Unix()}); err != nil {
+			a.Logger.Debugln("Dir size check:", err)
+		}
+	}
+
+	output := fmt.Sprintf("%s: %s in %d files", root, ByteCountSI(uint64(total)), files)
+	if partial {
+		output += " (partial)"
+	}
+	if len(failures) > 0 {
+		status = "failing"
+		output = fmt.Sprintf("%s: %s", output, strings.Join(failures, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":      data.CheckPK,
+		"status":  status,
+		"size":    total,
+		"files":   files,
+		"growth":  growth,
+		"partial": partial,
+		"largest": largest,
+		"output":  output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	diskHistoryMaxAge     = 7 * 24 * time.Hour
+	diskHistoryMaxSamples = 2000
+	diskHistoryMinSamples = 3
+	diskHistoryMinSpan    = 30 * time.Minute
+)
+
+type diskSample struct {
+	Time int64  `json:"time"`
+	Used uint64 `json:"used"`
+}
+
+// diskDaysToFull records the current usage and estimates the days until the disk is full
+// with a linear regression over the stored samples, returns -1 if usage isn't growing or there is not enough history
+func (a *WindowsAgent) diskDaysToFull(pk int, usage *disk.UsageStat) float64 {
+	historyFile := a.CheckDataFile(fmt.Sprintf("disk_%d.json", pk))
+	history := make([]diskSample, 0)
+	_ = LoadJSON(historyFile, &history)
+
+	now := time.Now()
+	cutoff := now.Add(-diskHistoryMaxAge).Unix()
+	kept := make([]diskSample, 0, len(history)+1)
+	for _, s := range history {
+		if s.Time >= cutoff {
+			kept = append(kept, s)
+		}
+	}
+	kept = append(kept, diskSample{Time: now.Unix(), Used: usage.Used})
+	if len(kept) > diskHistoryMaxSamples {
+		kept = downsampleDiskHistory(kept)
+	}
+
+	if err := SaveJSON(historyFile, kept); err != nil {
+		a.Logger.Debugln("Disk history:", err)
+	}
+
+	if len(kept) < diskHistoryMinSamples || time.Duration(kept[len(kept)-1].Time-kept[0].Time)*time.Second < diskHistoryMinSpan {
+		return -1
+	}
+
+	// least squares slope of bytes used per second, relative to the first sample to keep the numbers small
+	var sumX, sumY, sumXY, sumXX float64
+	n := float64(len(kept))
+	for _, s := range kept {
+		x := float64(s.Time - kept[0].Time)
+		y := float64(s.Used)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return -1
+	}
+	slope := (n*sumXY - sumX*sumY) / denom
+	if slope <= 0 {
+		return -1
+	}
+
+	return float64(usage.Free) / slope / 86400
+}
+
+// downsampleDiskHistory keeps the newest sample in each of diskHistoryMaxSamples equal time buckets across
+// the max age, so the history still spans the whole window at a coarser resolution instead of being cut short
+func downsampleDiskHistory(samples []diskSample) []diskSample {
+	bucket := int64(diskHistoryMaxAge/time.Second) / diskHistoryMaxSamples
+	ret := make([]diskSample, 0, diskHistoryMaxSamples+1)
+	for i, s := range samples {
+		if i+1 < len(samples) && samples[i+1].Time/bucket == s.Time/bucket {
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
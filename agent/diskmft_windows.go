@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const fsctlGetNtfsVolumeData = 0x00090064
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winioctl/ns-winioctl-ntfs_volume_data_buffer
+type ntfsVolumeDataBuffer struct {
+	VolumeSerialNumber           int64
+	NumberSectors                int64
+	TotalClusters                int64
+	FreeClusters                 int64
+	TotalReserved                int64
+	BytesPerSector               uint32
+	BytesPerCluster              uint32
+	BytesPerFileRecordSegment    uint32
+	ClustersPerFileRecordSegment uint32
+	MftValidDataLength           int64
+	MftStartLcn                  int64
+	Mft2StartLcn                 int64
+	MftZoneStart                 int64
+	MftZoneEnd                   int64
+}
+
+// MFTUsage is how much of an ntfs volume's master file table is in use, the closest ntfs has to inode usage
+type MFTUsage struct {
+	Records uint64  `json:"records"`
+	Total   uint64  `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// ntfsMFTUsage returns the mft records in use against the records the mft and its reserved zone can hold
+// Volumes that aren't ntfs return an error
+func ntfsMFTUsage(drive string) (MFTUsage, error) {
+	var ret MFTUsage
+	path, err := windows.UTF16PtrFromString(`\\.\` + strings.TrimRight(drive, `\`))
+	if err != nil {
+		return ret, err
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return ret, err
+	}
+	defer windows.CloseHandle(h)
+
+	var (
+		data     ntfsVolumeDataBuffer
+		returned uint32
+	)
+	err = windows.DeviceIoControl(h, fsctlGetNtfsVolumeData, nil, 0,
+		(*byte)(unsafe.Pointer(&data)), uint32(unsafe.Sizeof(data)), &returned, nil)
+	if err != nil {
+		return ret, err
+	}
+	if data.BytesPerFileRecordSegment == 0 {
+		return ret, fmt.Errorf("%s: no mft record size", drive)
+	}
+
+	recordSize := uint64(data.BytesPerFileRecordSegment)
+	zone := uint64(data.MftZoneEnd-data.MftZoneStart) * uint64(data.BytesPerCluster)
+	ret.Records = uint64(data.MftValidDataLength) / recordSize
+	ret.Total = ret.Records + zone/recordSize
+	if ret.Total > 0 {
+		ret.Percent = math.Round(float64(ret.Records)/float64(ret.Total)*10000) / 100
+	}
+	return ret, nil
+}
@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSCheck resolves a name against the system or a chosen resolver and checks the answers
+func (a *WindowsAgent) DNSCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	recordType := strings.ToUpper(data.RecordType)
+	if recordType == "" {
+		recordType = "A"
+	}
+
+	server := "system"
+	if data.Resolver != "" {
+		server = data.Resolver
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var (
+		answers []string
+		err     error
+	)
+	start := time.Now()
+	// the go resolver's custom dialer is ignored on windows, so queries to a chosen server are sent directly
+	if data.Resolver != "" {
+		answers, err = dnsQuery(ctx, server, recordType, data.Host)
+	} else {
+		answers, err = dnsLookup(ctx, net.DefaultResolver, recordType, data.Host)
+	}
+	queryMS := float64(time.Since(start).Microseconds()) / 1000
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+	)
+
+	if err != nil {
+		failures = append(failures, err.Error())
+	} else {
+		found := make(map[string]bool)
+		for _, ans := range answers {
+			found[dnsNormalize(ans)] = true
+		}
+		for _, want := range data.ExpectedAnswers {
+			if !found[dnsNormalize(want)] {
+				failures = append(failures, fmt.Sprintf("expected answer %s not returned", want))
+			}
+		}
+		if data.MaxResponseMS > 0 && queryMS > float64(data.MaxResponseMS) {
+			failures = append(failures, fmt.Sprintf("resolution time %.0f ms exceeds %d ms", queryMS, data.MaxResponseMS))
+		}
+	}
+
+	if len(failures) > 0 {
+		status = "failing"
+	}
+
+	output := fmt.Sprintf("%s %s via %s resolved in %.0f ms: %s", recordType, data.Host, server, queryMS, strings.Join(answers, ", "))
+	if len(failures) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(failures, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":          data.CheckPK,
+		"status":      status,
+		"resolver":    server,
+		"answers":     answers,
+		"response_ms": queryMS,
+		"output":      output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+func dnsLookup(ctx context.Context, resolver *net.Resolver, recordType, host string) ([]string, error) {
+	answers := make([]string, 0)
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, host)
+		if err != nil {
+			return answers, err
+		}
+		for _, ip := range ips {
+			answers = append(answers, ip.String())
+		}
+	case "CNAME":
+		cname, err := resolver.LookupCNAME(ctx, host)
+		if err != nil {
+			return answers, err
+		}
+		answers = append(answers, cname)
+	case "MX":
+		mxs, err := resolver.LookupMX(ctx, host)
+		if err != nil {
+			return answers, err
+		}
+		for _, mx := range mxs {
+			answers = append(answers, mx.Host)
+		}
+	case "TXT":
+		txts, err := resolver.LookupTXT(ctx, host)
+		if err != nil {
+			return answers, err
+		}
+		answers = append(answers, txts...)
+	default:
+		return answers, fmt.Errorf("unsupported record type %s", recordType)
+	}
+	sort.Strings(answers)
+	return answers, nil
+}
+
+var dnsQueryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// dnsQuery sends a single query to server over udp, retrying over tcp if the answer was truncated
+func dnsQuery(ctx context.Context, server, recordType, host string) ([]string, error) {
+	qtype, ok := dnsQueryTypes[recordType]
+	if !ok {
+		return []string{}, fmt.Errorf("unsupported record type %s", recordType)
+	}
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return []string{}, err
+	}
+
+	id := uint16(rand.Intn(0xffff))
+	b := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		return []string{}, err
+	}
+
+	resp, err := dnsExchange(ctx, "udp", server, query[2:])
+	if err != nil {
+		return []string{}, err
+	}
+	answers, truncated, err := dnsParse(resp, id, qtype)
+	if truncated {
+		// tcp messages are prefixed with their length
+		query[0], query[1] = byte(len(query[2:])>>8), byte(len(query[2:]))
+		if resp, err = dnsExchange(ctx, "tcp", server, query); err != nil {
+			return []string{}, err
+		}
+		answers, _, err = dnsParse(resp, id, qtype)
+	}
+	return answers, err
+}
+
+func dnsExchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	if network == "tcp" {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, int(length[0])<<8|int(length[1]))
+		_, err := io.ReadFull(conn, resp)
+		return resp, err
+	}
+
+	resp := make([]byte, 65535)
+	n, err := conn.Read(resp)
+	return resp[:n], err
+}
+
+// dnsParse returns the answers of the queried type, following cnames is left to the server
+func dnsParse(msg []byte, id uint16, qtype dnsmessage.Type) (answers []string, truncated bool, err error) {
+	answers = make([]string, 0)
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return answers, false, err
+	}
+	if h.ID != id {
+		return answers, false, errors.New("dns response id mismatch")
+	}
+	if h.Truncated {
+		return answers, true, nil
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return answers, false, errors.New("no such host")
+	default:
+		return answers, false, fmt.Errorf("server returned %s", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return answers, false, err
+	}
+
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return answers, false, err
+		}
+		if rh.Type != qtype {
+			p.SkipAnswer()
+			continue
+		}
+		switch qtype {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return answers, false, err
+			}
+			answers = append(answers, net.IP(r.A[:]).String())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return answers, false, err
+			}
+			answers = append(answers, net.IP(r.AAAA[:]).String())
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return answers, false, err
+			}
+			answers = append(answers, r.CNAME.String())
+		case dnsmessage.TypeMX:
+			r, err := p.MXResource()
+			if err != nil {
+				return answers, false, err
+			}
+			answers = append(answers, r.MX.String())
+		case dnsmessage.TypeTXT:
+			r, err := p.TXTResource()
+			if err != nil {
+				return answers, false, err
+			}
+			answers = append(answers, strings.Join(r.TXT, ""))
+		}
+	}
+
+	if len(answers) == 0 {
+		return answers, false, fmt.Errorf("no %s records", qtype)
+	}
+	sort.Strings(answers)
+	return answers, false, nil
+}
+
+func dnsNormalize(s string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// FileAgeCheck finds the newest file matching the paths and globs and checks its age and size
+func (a *WindowsAgent) FileAgeCheck(data rmm.Check, r *resty.Client) {
+	var (
+		newestPath string
+		newest     os.FileInfo
+		matches    int
+	)
+	for _, pattern := range data.Paths {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			a.Logger.Debugln("File age check:", err)
+			continue
+		}
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			matches++
+			if newest == nil || fi.ModTime().After(newest.ModTime()) {
+				newestPath, newest = f, fi
+			}
+		}
+	}
+
+	if newest == nil {
+		payload := map[string]interface{}{
+			"id":      data.CheckPK,
+			"status":  "failing",
+			"matches": 0,
+			"output":  fmt.Sprintf("No files match %s", strings.Join(data.Paths, ", ")),
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+		return
+	}
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+		ageHours = time.Since(newest.ModTime()).Hours()
+	)
+	if data.MaxAgeHours > 0 && ageHours > data.MaxAgeHours {
+		failures = append(failures, fmt.Sprintf("newest file is %.1f hours old, max %.1f", ageHours, data.MaxAgeHours))
+	}
+	if data.MinSizeBytes > 0 && newest.Size() < data.MinSizeBytes {
+		failures = append(failures, fmt.Sprintf("newest file is %s, min %s", ByteCountSI(uint64(newest.Size())), ByteCountSI(uint64(data.MinSizeBytes))))
+	}
+
+	output := fmt.Sprintf("%s modified %s (%.1f hours ago), %s", newestPath, newest.ModTime().Format(time.RFC3339), ageHours, ByteCountSI(uint64(newest.Size())))
+	if len(failures) > 0 {
+		status = "failing"
+		output = fmt.Sprintf("%s: %s", output, strings.Join(failures, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":        data.CheckPK,
+		"status":    status,
+		"matches":   matches,
+		"path":      newestPath,
+		"mtime":     newest.ModTime().Unix(),
+		"size":      newest.Size(),
+		"age_hours": ageHours,
+		"output":    output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	defaultDirPageSize = 500
+	maxDirPageSize     = 5000
+	// how long a sorted listing is kept for fetching its later pages
+	dirListingTTL = 2 * time.Minute
+)
+
+// dirListings caches the sorted names of recently listed directories so paging doesn't re-read and re-sort them
+var dirListings = &dirListingCache{dirs: make(map[string]dirListingNames)}
+
+type dirListingCache struct {
+	mu   sync.Mutex
+	dirs map[string]dirListingNames
+}
+
+type dirListingNames struct {
+	names []string
+	read  time.Time
+}
+
+// FileEntry holds info for a single file or directory
+type FileEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`
+	IsDir   bool   `json:"is_dir"`
+	Owner   string `json:"owner"`
+	ModTime int64  `json:"mtime"`
+	Target  string `json:"symlink_target"`
+}
+
+// DirListing is a single page of a directory listing
+type DirListing struct {
+	Path    string      `json:"path"`
+	Entries []FileEntry `json:"entries"`
+	Offset  int         `json:"offset"`
+	Total   int         `json:"total"`
+	Error   string      `json:"error"`
+}
+
+// ListDir returns a sorted page of directory entries
+func (a *WindowsAgent) ListDir(path string, offset, limit int) DirListing {
+	ret := DirListing{Path: path, Offset: offset, Entries: make([]FileEntry, 0)}
+
+	clean, err := a.PathAllowed(path)
+	a.AuditLog("listdir", path, err)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	// the first page always reads the directory, later pages reuse that listing while it is fresh
+	names, err := dirListings.names(clean, offset == 0)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Total = len(names)
+
+	if limit <= 0 {
+		limit = defaultDirPageSize
+	} else if limit > maxDirPageSize {
+		limit = maxDirPageSize
+	}
+	if offset < 0 || offset > len(names) {
+		offset = 0
+		ret.Offset = 0
+	}
+	end := offset + limit
+	if end > len(names) {
+		end = len(names)
+	}
+
+	for _, name := range names[offset:end] {
+		entry, err := fileEntry(filepath.Join(clean, name))
+		if err != nil {
+			continue
+		}
+		ret.Entries = append(ret.Entries, entry)
+	}
+	return ret
+}
+
+// names returns the sorted names in dir, reading it again if refresh is set or the cached listing is stale
+func (c *dirListingCache) names(dir string, refresh bool) ([]string, error) {
+	key := strings.ToLower(dir)
+	now := time.Now()
+
+	c.mu.Lock()
+	for k, d := range c.dirs {
+		if now.Sub(d.read) > dirListingTTL {
+			delete(c.dirs, k)
+		}
+	}
+	d, ok := c.dirs[key]
+	c.mu.Unlock()
+	if ok && !refresh {
+		return d.names, nil
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	c.mu.Lock()
+	c.dirs[key] = dirListingNames{names: names, read: now}
+	c.mu.Unlock()
+	return names, nil
+}
+
+// StatFile returns info for a single path
+func (a *WindowsAgent) StatFile(path string) (FileEntry, error) {
+	clean, err := a.PathAllowed(path)
+	a.AuditLog("statfile", path, err)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return fileEntry(clean)
+}
+
+// MakeDir creates a directory along with any missing parents
+func (a *WindowsAgent) MakeDir(path string) error {
+	clean, err := a.PathAllowed(path)
+	if err == nil {
+		err = os.MkdirAll(clean, 0755)
+	}
+	a.AuditLog("mkdir", path, err)
+	return err
+}
+
+// RenameFile renames or moves a file or directory, both paths must be allowed
+func (a *WindowsAgent) RenameFile(oldPath, newPath string) error {
+	src, err := a.PathAllowed(oldPath)
+	if err == nil {
+		var dst string
+		dst, err = a.PathAllowed(newPath)
+		if err == nil {
+			err = os.Rename(src, dst)
+		}
+	}
+	a.AuditLog("rename", fmt.Sprintf("%s -> %s", oldPath, newPath), err)
+	return err
+}
+
+// DeleteFile removes a file or an empty directory, or a whole tree if recursive
+func (a *WindowsAgent) DeleteFile(path string, recursive bool) error {
+	clean, err := a.PathAllowed(path)
+	if err == nil && filepath.Dir(clean) == clean {
+		err = fmt.Errorf("%s: refusing to delete a drive root", clean)
+	}
+	if err == nil {
+		if recursive {
+			err = os.RemoveAll(clean)
+		} else {
+			err = os.Remove(clean)
+		}
+	}
+	a.AuditLog("delete", path, err)
+	return err
+}
+
+func fileEntry(path string) (FileEntry, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return FileEntry{}, err
+	}
+
+	entry := FileEntry{
+		Name:    fi.Name(),
+		Path:    path,
+		Size:    fi.Size(),
+		Mode:    fi.Mode().String(),
+		IsDir:   fi.IsDir(),
+		Owner:   fileOwner(path),
+		ModTime: fi.ModTime().Unix(),
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		entry.Target, _ = os.Readlink(path)
+	}
+	return entry, nil
+}
+
+// fileOwner returns the owner of a file as DOMAIN\user
+func fileOwner(path string) string {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return ""
+	}
+	sid, _, err := sd.Owner()
+	if err != nil || sid == nil {
+		return ""
+	}
+	account, domain, _, err := sid.LookupAccount("")
+	if err != nil {
+		return sid.String()
+	}
+	if domain == "" {
+		return account
+	}
+	return fmt.Sprintf(`%s\%s`, domain, account)
+}
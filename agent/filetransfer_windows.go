@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultChunkSize = 256 * 1024
+	// keep chunks well under the default nats max payload of 1MB
+	maxChunkSize = 512 * 1024
+	partSuffix   = ".trmmpart"
+	// records which upload a part file belongs to
+	partInfoSuffix = partSuffix + ".json"
+)
+
+// FileTransfer is the reply for both fileget and fileput
+type FileTransfer struct {
+	Path     string  `json:"path"`
+	Offset   int64   `json:"offset"`
+	Size     int64   `json:"size"`
+	Data     []byte  `json:"data"`
+	Done     bool    `json:"done"`
+	SHA256   string  `json:"sha256"`
+	Progress float64 `json:"progress"`
+	Error    string  `json:"error"`
+}
+
+type partFileInfo struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// PathAllowed cleans the path and makes sure it falls under one of the allowed path prefixes
+// If no prefixes are configured every absolute path is allowed
+func (a *WindowsAgent) PathAllowed(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s: path must be absolute", path)
+	}
+	path = filepath.Clean(path)
+
+	if len(a.AllowedPaths) == 0 {
+		return path, nil
+	}
+
+	// resolve links so they can't be used to escape the allowed folders
+	resolved := path
+	if r, err := filepath.EvalSymlinks(path); err == nil {
+		resolved = r
+	} else if r, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		resolved = filepath.Join(r, filepath.Base(path))
+	}
+
+	for _, prefix := range a.AllowedPaths {
+		prefix = filepath.Clean(prefix)
+		if prefix == "." {
+			continue
+		}
+		if strings.EqualFold(resolved, prefix) {
+			return path, nil
+		}
+		if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+			prefix += string(os.PathSeparator)
+		}
+		if strings.HasPrefix(strings.ToLower(resolved), strings.ToLower(prefix)) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: path is not allowed", path)
+}
+
+// FileGet reads a single chunk of a file starting at offset
+// The sha256 of the whole file is returned with the last chunk so the caller can verify the transfer
+// Interrupted transfers are resumed by requesting the next offset
+func (a *WindowsAgent) FileGet(path string, offset int64, chunkSize int) FileTransfer {
+	ret := FileTransfer{Path: path, Offset: offset}
+
+	path, err := a.PathAllowed(path)
+	if offset == 0 || err != nil {
+		a.AuditLog("fileget", ret.Path, err)
+	}
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	if fi.IsDir() {
+		ret.Error = fmt.Sprintf("%s is a directory", path)
+		return ret
+	}
+
+	ret.Size = fi.Size()
+	if ret.Size > a.MaxFileSize {
+		ret.Error = fmt.Sprintf("file size %s exceeds the limit of %s", ByteCountSI(uint64(ret.Size)), ByteCountSI(uint64(a.MaxFileSize)))
+		return ret
+	}
+	if offset < 0 || offset > ret.Size {
+		ret.Error = fmt.Sprintf("invalid offset %d for file of size %d", offset, ret.Size)
+		return ret
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	} else if chunkSize > maxChunkSize {
+		chunkSize = maxChunkSize
+	}
+
+	buf := make([]byte, chunkSize)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Data = buf[:n]
+	ret.Progress = transferProgress(offset+int64(n), ret.Size)
+
+	if offset+int64(n) >= ret.Size {
+		ret.Done = true
+		ret.SHA256, err = fileSHA256(path)
+		if err != nil {
+			ret.Error = err.Error()
+		}
+	}
+	return ret
+}
+
+// FilePut writes a single chunk to a temporary part file next to the destination
+// An empty chunk only reports how much of this upload has already been received so the caller can resume from there
+// Sending offset 0 restarts the transfer
+// Once all bytes are received the sha256 is verified and the part file is moved into place
+func (a *WindowsAgent) FilePut(path string, offset, size int64, expectedHash string, data []byte) FileTransfer {
+	ret := FileTransfer{Path: path, Size: size}
+
+	path, err := a.PathAllowed(path)
+	if err != nil {
+		a.AuditLog("fileput", ret.Path, err)
+		ret.Error = err.Error()
+		return ret
+	}
+
+	if size > a.MaxFileSize {
+		ret.Error = fmt.Sprintf("file size %s exceeds the limit of %s", ByteCountSI(uint64(size)), ByteCountSI(uint64(a.MaxFileSize)))
+		return ret
+	}
+	if expectedHash == "" {
+		ret.Error = "missing sha256"
+		return ret
+	}
+
+	part := path + partSuffix
+	partInfo := path + partInfoSuffix
+	// a part file is only resumed by the upload that started it, one left by a different upload starts over
+	var (
+		received int64
+		info     partFileInfo
+	)
+	if err := LoadJSON(partInfo, &info); err == nil && strings.EqualFold(info.SHA256, expectedHash) && info.Size == size {
+		if fi, err := os.Stat(part); err == nil {
+			received = fi.Size()
+		}
+	}
+
+	if len(data) == 0 && size > 0 {
+		ret.Offset = received
+		ret.Progress = transferProgress(received, size)
+		return ret
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case offset == 0:
+		flags |= os.O_TRUNC
+	case offset != received:
+		ret.Offset = received
+		ret.Error = fmt.Sprintf("expected offset %d, got %d", received, offset)
+		return ret
+	}
+
+	if offset+int64(len(data)) > size {
+		ret.Offset = received
+		ret.Error = "chunk exceeds the declared file size"
+		return ret
+	}
+
+	if offset == 0 {
+		if err := SaveJSON(partInfo, partFileInfo{SHA256: expectedHash, Size: size}); err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	n, err := f.WriteAt(data, offset)
+	f.Close()
+	ret.Offset = offset + int64(n)
+	ret.Progress = transferProgress(ret.Offset, size)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	if ret.Offset < size {
+		return ret
+	}
+
+	ret.SHA256, err = fileSHA256(part)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	if !strings.EqualFold(ret.SHA256, expectedHash) {
+		os.Remove(part)
+		os.Remove(partInfo)
+		ret.Offset = 0
+		ret.Error = fmt.Sprintf("sha256 mismatch, expected %s got %s", expectedHash, ret.SHA256)
+		return ret
+	}
+
+	err = os.Rename(part, path)
+	a.AuditLog("fileput", path, err)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	os.Remove(partInfo)
+	ret.Done = true
+	return ret
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func transferProgress(done, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(done) / float64(total) * 100
+}
@@ -0,0 +1,398 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const (
+	fimWatchFile     = "fim_watch.json"
+	fimMaxEvents     = 500
+	fimMaxEventFiles = 100
+	fimMaxWatchDirs  = 2000
+	fimReloadWatches = 60 * time.Second
+)
+
+// fimWatchMu guards the watch list's read-modify-write, checks run concurrently under the scheduler
+var fimWatchMu sync.Mutex
+
+// FIMEntry is the baseline info stored for a single file
+type FIMEntry struct {
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`
+	Owner   string `json:"owner"`
+	SHA256  string `json:"sha256"`
+	ModTime int64  `json:"mtime"`
+}
+
+// FIMChange describes a file that differs from the baseline
+type FIMChange struct {
+	Path    string   `json:"path"`
+	Changes []string `json:"changes"`
+}
+
+// FIMEvent is a change seen by the watcher between check runs
+type FIMEvent struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	Time int64  `json:"time"`
+}
+
+// FileIntegrityCheck compares the configured paths and globs against a stored baseline
+// and reports files that were added, modified or deleted
+func (a *WindowsAgent) FileIntegrityCheck(data rmm.Check, r *resty.Client) {
+	baselineFile := a.CheckDataFile(fmt.Sprintf("fim_%d.json", data.CheckPK))
+	current := a.fimSnapshot(data.Paths)
+
+	var (
+		added    = make([]string, 0)
+		deleted  = make([]string, 0)
+		modified = make([]FIMChange, 0)
+		created  bool
+	)
+
+	baseline := make(map[string]FIMEntry)
+	if err := LoadJSON(baselineFile, &baseline); err != nil {
+		created = true
+	} else {
+		added, deleted, modified = fimDiff(baseline, current)
+	}
+
+	if created || data.UpdateBaseline {
+		if err := SaveJSON(baselineFile, current); err != nil {
+			a.Logger.Debugln("FIM baseline:", err)
+		}
+	}
+
+	a.fimSetWatch(data.CheckPK, data.Paths, data.WatchPaths)
+	events := a.fimPopEvents(data.CheckPK)
+
+	status := "passing"
+	if len(added)+len(deleted)+len(modified)+len(events) > 0 {
+		status = "failing"
+	}
+
+	payload := map[string]interface{}{
+		"id":               data.CheckPK,
+		"status":           status,
+		"baseline_created": created,
+		"files":            len(current),
+		"added":            added,
+		"deleted":          deleted,
+		"modified":         modified,
+		"events":           events,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// fimSnapshot expands globs and walks directories, hashing every regular file found
+func (a *WindowsAgent) fimSnapshot(paths []string) map[string]FIMEntry {
+	ret := make(map[string]FIMEntry)
+	for _, pattern := range paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			a.Logger.Debugln("FIM glob:", err)
+			continue
+		}
+		for _, m := range matches {
+			filepath.Walk(m, func(path string, fi os.FileInfo, err error) error {
+				if err != nil || !fi.Mode().IsRegular() {
+					return nil
+				}
+				hash, err := fileSHA256(path)
+				if err != nil {
+					a.Logger.Debugln("FIM hash:", err)
+				}
+				ret[path] = FIMEntry{
+					Size:    fi.Size(),
+					Mode:    fi.Mode().String(),
+					Owner:   fileOwner(path),
+					SHA256:  hash,
+					ModTime: fi.ModTime().Unix(),
+				}
+				return nil
+			})
+		}
+	}
+	return ret
+}
+
+func fimDiff(baseline, current map[string]FIMEntry) (added, deleted []string, modified []FIMChange) {
+	added = make([]string, 0)
+	deleted = make([]string, 0)
+	modified = make([]FIMChange, 0)
+
+	for path, cur := range current {
+		old, ok := baseline[path]
+		if !ok {
+			added = append(added, path)
+			continue
+		}
+
+		changes := make([]string, 0)
+		if old.SHA256 != cur.SHA256 {
+			changes = append(changes, "content")
+		}
+		if old.Size != cur.Size {
+			changes = append(changes, "size")
+		}
+		if old.Mode != cur.Mode {
+			changes = append(changes, "mode")
+		}
+		if old.Owner != cur.Owner {
+			changes = append(changes, "owner")
+		}
+		if len(changes) > 0 {
+			modified = append(modified, FIMChange{Path: path, Changes: changes})
+		}
+	}
+
+	for path := range baseline {
+		if _, ok := current[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(deleted)
+	sort.Slice(modified, func(i, j int) bool { return modified[i].Path < modified[j].Path })
+	return
+}
+
+// fimSetWatch registers or removes the check's paths from the list the watcher picks up
+func (a *WindowsAgent) fimSetWatch(pk int, paths []string, watch bool) {
+	fimWatchMu.Lock()
+	defer fimWatchMu.Unlock()
+
+	watchFile := a.CheckDataFile(fimWatchFile)
+	watches := make(map[string][]string)
+	_ = LoadJSON(watchFile, &watches)
+
+	key := strconv.Itoa(pk)
+	if watch {
+		watches[key] = paths
+	} else if _, ok := watches[key]; ok {
+		delete(watches, key)
+	} else {
+		return
+	}
+
+	if err := SaveJSON(watchFile, watches); err != nil {
+		a.Logger.Debugln("FIM watch:", err)
+	}
+}
+
+// fimPopEvents returns and clears the changes the watcher recorded since the last run
+// The watcher runs in the agent service and writes each flush to its own file, so files are only ever
+// read and removed here and events can't be lost to a flush racing with a check run
+func (a *WindowsAgent) fimPopEvents(pk int) []FIMEvent {
+	events := make([]FIMEvent, 0)
+	files, _ := filepath.Glob(a.CheckDataFile(fmt.Sprintf("fim_events_%d_*.json", pk)))
+	sort.Strings(files)
+	for _, f := range files {
+		batch := make([]FIMEvent, 0)
+		if err := LoadJSON(f, &batch); err == nil {
+			events = append(events, batch...)
+		}
+		os.Remove(f)
+	}
+	if len(events) > fimMaxEvents {
+		events = events[len(events)-fimMaxEvents:]
+	}
+	return events
+}
+
+// FileIntegrityWatcher watches the paths of fileintegrity checks that have watching enabled
+// and records every change so short lived modifications between check runs are not missed
+func (a *WindowsAgent) FileIntegrityWatcher() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		a.Logger.Errorln("FIM watcher:", err)
+		return
+	}
+	defer watcher.Close()
+
+	var (
+		mu      sync.Mutex
+		pending = make(map[string][]FIMEvent)
+		watched = make(map[string]bool)
+		// watched dir -> check pks interested in it
+		owners = make(map[string][]string)
+		// check pk -> configured patterns and the paths they matched
+		patterns = make(map[string][]string)
+		roots    = make(map[string][]string)
+	)
+
+	reload := func() {
+		watches := make(map[string][]string)
+		_ = LoadJSON(a.CheckDataFile(fimWatchFile), &watches)
+
+		dirs := make(map[string][]string)
+		matched := make(map[string][]string)
+		for pk, paths := range watches {
+			var pkDirs []string
+			pkDirs, matched[pk] = fimWatchDirs(paths)
+			for _, dir := range pkDirs {
+				if len(dirs) >= fimMaxWatchDirs {
+					break
+				}
+				if len(dirs[dir]) > 0 && dirs[dir][len(dirs[dir])-1] == pk {
+					continue
+				}
+				dirs[dir] = append(dirs[dir], pk)
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for dir := range watched {
+			if _, ok := dirs[dir]; !ok {
+				watcher.Remove(dir)
+				delete(watched, dir)
+			}
+		}
+		for dir := range dirs {
+			if !watched[dir] {
+				if err := watcher.Add(dir); err != nil {
+					a.Logger.Debugln("FIM watch:", err)
+					continue
+				}
+				watched[dir] = true
+			}
+		}
+		owners = dirs
+		patterns = watches
+		roots = matched
+	}
+
+	flush := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for pk, evts := range pending {
+			// zero padded so the files sort in the order they were written
+			eventsFile := a.CheckDataFile(fmt.Sprintf("fim_events_%s_%020d.json", pk, time.Now().UnixNano()))
+			if len(evts) > fimMaxEvents {
+				evts = evts[len(evts)-fimMaxEvents:]
+			}
+			if err := SaveJSON(eventsFile, evts); err != nil {
+				a.Logger.Debugln("FIM events:", err)
+			}
+
+			// drop the oldest batches if the check hasn't run for a while
+			files, _ := filepath.Glob(a.CheckDataFile(fmt.Sprintf("fim_events_%s_*.json", pk)))
+			if len(files) > fimMaxEventFiles {
+				sort.Strings(files)
+				for _, f := range files[:len(files)-fimMaxEventFiles] {
+					os.Remove(f)
+				}
+			}
+		}
+		pending = make(map[string][]FIMEvent)
+	}
+
+	reload()
+	reloadTicker := time.NewTicker(fimReloadWatches)
+	flushTicker := time.NewTicker(5 * time.Second)
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			mu.Lock()
+			for _, pk := range owners[filepath.Dir(ev.Name)] {
+				if !fimWatches(patterns[pk], roots[pk], ev.Name) {
+					continue
+				}
+				pending[pk] = append(pending[pk], FIMEvent{Path: ev.Name, Op: strings.ToLower(ev.Op.String()), Time: time.Now().Unix()})
+			}
+			mu.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			a.Logger.Debugln("FIM watcher:", err)
+		case <-flushTicker.C:
+			flush()
+		case <-reloadTicker.C:
+			reload()
+		}
+	}
+}
+
+// fimWatchDirs returns every directory that needs to be watched to cover the given paths and globs
+// along with the paths the globs currently match
+func fimWatchDirs(paths []string) (dirs, matched []string) {
+	dirs = make([]string, 0)
+	matched = make([]string, 0)
+	for _, pattern := range paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		// watch the parent so new files matching the glob are seen
+		dirs = append(dirs, globBase(filepath.Dir(pattern)))
+		for _, m := range matches {
+			matched = append(matched, m)
+			fi, err := os.Stat(m)
+			if err != nil {
+				continue
+			}
+			if !fi.IsDir() {
+				dirs = append(dirs, filepath.Dir(m))
+				continue
+			}
+			filepath.Walk(m, func(path string, fi os.FileInfo, err error) error {
+				if err == nil && fi.IsDir() {
+					dirs = append(dirs, path)
+				}
+				return nil
+			})
+		}
+	}
+	return
+}
+
+// fimWatches checks if a changed path is covered by a check's patterns
+func fimWatches(patterns, roots []string, path string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+	for _, root := range roots {
+		if strings.EqualFold(path, root) || strings.HasPrefix(strings.ToLower(path), strings.ToLower(root)+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// globBase returns the leading part of a pattern that has no glob characters
+func globBase(pattern string) string {
+	vol := filepath.VolumeName(pattern)
+	parts := strings.Split(pattern[len(vol):], string(os.PathSeparator))
+	base := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			break
+		}
+		base = append(base, part)
+	}
+	if len(base) == 1 && base[0] == "" {
+		return vol + string(os.PathSeparator)
+	}
+	return vol + strings.Join(base, string(os.PathSeparator))
+}
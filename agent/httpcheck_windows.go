@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// HTTPCheck requests a url and checks the status code, body, response time and certificate expiry
+func (a *WindowsAgent) HTTPCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: data.SkipTLSVerify,
+		ServerName:         data.TLSServerName,
+	}
+	if data.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM([]byte(data.CACert))
+		tlsConfig.RootCAs = pool
+	}
+
+	client := resty.New()
+	client.SetTimeout(time.Duration(timeout) * time.Second)
+	client.SetTLSClientConfig(tlsConfig)
+	client.SetHeaders(data.Headers)
+	client.SetDebug(a.Debug)
+
+	method := strings.ToUpper(data.Method)
+	if method == "" {
+		method = "GET"
+	}
+
+	req := client.R()
+	if data.Body != "" {
+		req.SetBody(data.Body)
+	}
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+		warnings = make([]string, 0)
+		daysLeft = -1
+	)
+
+	resp, err := req.Execute(method, data.URL)
+	if err != nil {
+		payload := map[string]interface{}{
+			"id":     data.CheckPK,
+			"status": "failing",
+			"output": err.Error(),
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+		return
+	}
+
+	statusMin, statusMax := data.StatusMin, data.StatusMax
+	if statusMin == 0 && statusMax == 0 {
+		statusMin, statusMax = 200, 399
+	} else if statusMax == 0 {
+		statusMax = statusMin
+	}
+	if resp.StatusCode() < statusMin || resp.StatusCode() > statusMax {
+		failures = append(failures, fmt.Sprintf("status code %d not in %d-%d", resp.StatusCode(), statusMin, statusMax))
+	}
+
+	body := resp.String()
+	if data.BodyContains != "" && !strings.Contains(body, data.BodyContains) {
+		failures = append(failures, fmt.Sprintf("body does not contain %q", data.BodyContains))
+	}
+	if data.Expect != "" {
+		re, err := regexp.Compile(data.Expect)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("invalid expect regex: %v", err))
+		} else if !re.MatchString(body) {
+			failures = append(failures, fmt.Sprintf("body does not match %q", data.Expect))
+		}
+	}
+
+	responseMS := float64(resp.Time().Microseconds()) / 1000
+	if data.MaxResponseMS > 0 && responseMS > float64(data.MaxResponseMS) {
+		failures = append(failures, fmt.Sprintf("response time %.0f ms exceeds %d ms", responseMS, data.MaxResponseMS))
+	}
+
+	if tlsState := resp.RawResponse.TLS; tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		daysLeft = int(math.Floor(time.Until(tlsState.PeerCertificates[0].NotAfter).Hours() / 24))
+		switch {
+		case data.CritDays > 0 && daysLeft <= data.CritDays:
+			failures = append(failures, fmt.Sprintf("certificate expires in %d days", daysLeft))
+		case data.WarnDays > 0 && daysLeft <= data.WarnDays:
+			warnings = append(warnings, fmt.Sprintf("certificate expires in %d days", daysLeft))
+		}
+	}
+
+	if len(failures) > 0 {
+		status = "failing"
+	} else if len(warnings) > 0 {
+		status = "warning"
+	}
+
+	output := fmt.Sprintf("%s %s returned %d in %.0f ms", method, data.URL, resp.StatusCode(), responseMS)
+	if msgs := append(failures, warnings...); len(msgs) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(msgs, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":          data.CheckPK,
+		"status":      status,
+		"status_code": resp.StatusCode(),
+		"response_ms": responseMS,
+		"cert_days":   daysLeft,
+		"output":      output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+	"golang.org/x/sys/windows"
+)
+
+const maxLogFileMatches = 100
+
+type logFileState struct {
+	FileID string `json:"file_id"`
+	Offset int64  `json:"offset"`
+}
+
+// LogFileCheck reads new lines from log files since the last run and fails when enough lines match
+func (a *WindowsAgent) LogFileCheck(data rmm.Check, r *resty.Client) {
+	var include, exclude *regexp.Regexp
+	var err error
+	if data.IncludeRegex != "" {
+		if include, err = regexp.Compile(data.IncludeRegex); err != nil {
+			a.logFileCheckError(data, r, fmt.Sprintf("Invalid include regex: %v", err))
+			return
+		}
+	}
+	if data.ExcludeRegex != "" {
+		if exclude, err = regexp.Compile(data.ExcludeRegex); err != nil {
+			a.logFileCheckError(data, r, fmt.Sprintf("Invalid exclude regex: %v", err))
+			return
+		}
+	}
+
+	stateFile := a.CheckDataFile(fmt.Sprintf("logfile_%d.json", data.CheckPK))
+	state := make(map[string]logFileState)
+	// without a state file the check has never run, so files start at the end and old entries don't trigger it
+	// after that any new file, like a date named log, is read from the start
+	firstRun := LoadJSON(stateFile, &state) != nil
+	newState := make(map[string]logFileState)
+
+	// a rotated log keeps its file id under its new name, so it carries on from its saved offset
+	byID := make(map[string]logFileState)
+	for _, st := range state {
+		if st.FileID != "" {
+			byID[st.FileID] = st
+		}
+	}
+	rotated := func(path, id string) bool {
+		_, moved := byID[id]
+		return id != "" && moved && state[path].FileID != id
+	}
+
+	matches := make([]rmm.EventLogMsg, 0)
+	total := 0
+	errs := make([]string, 0)
+
+	paths := make([]string, 0)
+	ids := make(map[string]string)
+	for _, pattern := range data.Paths {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, path := range files {
+			if _, dup := ids[path]; !dup {
+				ids[path] = logFileID(path)
+				paths = append(paths, path)
+			}
+		}
+	}
+	// drain rotated files before the logs that replaced them so the lines are reported in order
+	sort.SliceStable(paths, func(i, j int) bool {
+		return rotated(paths[i], ids[paths[i]]) && !rotated(paths[j], ids[paths[j]])
+	})
+
+	for _, path := range paths {
+		prev, seen := state[path]
+		known := func(id string) (int64, bool) {
+			if seen && prev.FileID == id {
+				return prev.Offset, true
+			}
+			if rotated(path, id) {
+				return byID[id].Offset, true
+			}
+			return 0, false
+		}
+		// lines are filtered as they are read so a large backlog is never held in memory
+		st, err := scanNewLogLines(path, known, firstRun, func(line string) {
+			if include != nil && !include.MatchString(line) {
+				return
+			}
+			if exclude != nil && exclude.MatchString(line) {
+				return
+			}
+			total++
+			if len(matches) < maxLogFileMatches {
+				matches = append(matches, rmm.EventLogMsg{
+					Source:    path,
+					EventType: "MATCH",
+					Message:   line,
+					Time:      time.Now().Round(0).String(),
+					UID:       len(matches) + 1,
+				})
+			}
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			if seen {
+				newState[path] = prev
+			}
+			continue
+		}
+		newState[path] = st
+	}
+
+	if err := SaveJSON(stateFile, newState); err != nil {
+		a.Logger.Debugln("Logfile check:", err)
+	}
+
+	threshold := data.MatchCount
+	if threshold <= 0 {
+		threshold = 1
+	}
+	status := "passing"
+	if total >= threshold {
+		status = "failing"
+	}
+
+	payload := map[string]interface{}{
+		"id":      data.CheckPK,
+		"status":  status,
+		"matches": total,
+		"log":     matches,
+		"errors":  errs,
+		"output":  fmt.Sprintf("%d matching lines in %d files", total, len(newState)),
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+func (a *WindowsAgent) logFileCheckError(data rmm.Check, r *resty.Client, msg string) {
+	payload := map[string]interface{}{
+		"id":     data.CheckPK,
+		"status": "failing",
+		"output": msg,
+	}
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// scanNewLogLines calls fn with each complete line written since the saved offset
+// known returns the saved offset for a file id, a file with an unknown id is new or the log was rotated so it is read
+// from the start, as is a file smaller than its offset. New files start at the end instead when atEnd is set
+func scanNewLogLines(path string, known func(id string) (int64, bool), atEnd bool, fn func(line string)) (logFileState, error) {
+	f, err := openShared(path)
+	if err != nil {
+		return logFileState{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return logFileState{}, err
+	}
+
+	st := logFileState{FileID: fileID(f)}
+	offset, ok := known(st.FileID)
+	switch {
+	case !ok && atEnd:
+		st.Offset = fi.Size()
+		return st, nil
+	case !ok, fi.Size() < offset:
+		st.Offset = 0
+	default:
+		st.Offset = offset
+	}
+
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		return logFileState{}, err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// leave partial lines for the next run
+			break
+		}
+		st.Offset += int64(len(line))
+		fn(strings.TrimRight(line, "\r\n"))
+	}
+	return st, nil
+}
+
+// logFileID returns the file id of path, or an empty string if it can't be opened
+func logFileID(path string) string {
+	f, err := openShared(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return fileID(f)
+}
+
+// fileID returns a string that uniquely identifies a file on the system, used to detect log rotation
+func fileID(f *os.File) string {
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(f.Fd()), &info); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x%08x", info.VolumeSerialNumber, info.FileIndexHigh, info.FileIndexLow)
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"math"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/go-resty/resty/v2"
+	"github.com/shirou/gopsutil/v3/mem"
+	gops "github.com/shirou/gopsutil/v3/process"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+type win32PerfOSMemory struct {
+	PageFaultsPersec uint32
+	PagesInputPersec uint32
+	CommittedBytes   uint64
+	CommitLimit      uint64
+}
+
+// MemCheck checks mem percentage, swap and commit usage, paging rates and the top memory consumers
+func (a *WindowsAgent) MemCheck(data rmm.Check, r *resty.Client) {
+	procs, err := gops.Processes()
+	if err != nil {
+		a.Logger.Debugln("Memory check:", err)
+	}
+	// prime the cpu counters so the top processes have something useful in cpu_percent
+	for _, p := range procs {
+		p.Percent(0)
+	}
+	time.Sleep(2 * time.Second)
+
+	payload := map[string]interface{}{
+		"id": data.CheckPK,
+	}
+
+	if vm, err := mem.VirtualMemory(); err == nil {
+		// the percent is of memory not available, so the standby cache isn't counted as used
+		percent := float64(vm.Total-vm.Available) / float64(vm.Total) * 100
+		payload["percent"] = int(math.Round(percent))
+		payload["total"] = vm.Total
+		payload["available"] = vm.Available
+	} else {
+		a.Logger.Debugln("Memory check:", err)
+	}
+
+	if swap, err := mem.SwapMemory(); err == nil {
+		payload["swap_total"] = swap.Total
+		payload["swap_used"] = swap.Used
+		payload["swap_percent"] = math.Round(swap.UsedPercent*10) / 10
+	} else {
+		a.Logger.Debugln("Memory check:", err)
+	}
+
+	var perf []win32PerfOSMemory
+	q := "SELECT PageFaultsPersec, PagesInputPersec, CommittedBytes, CommitLimit FROM Win32_PerfFormattedData_PerfOS_Memory"
+	if err := wmi.Query(q, &perf); err == nil && len(perf) > 0 {
+		payload["page_faults_per_sec"] = perf[0].PageFaultsPersec
+		payload["pages_input_per_sec"] = perf[0].PagesInputPersec
+		payload["commit_used"] = perf[0].CommittedBytes
+		payload["commit_limit"] = perf[0].CommitLimit
+	} else if err != nil {
+		a.Logger.Debugln("Memory check:", err)
+	}
+
+	payload["top_processes"] = topProcesses(procs, func(p TopProcess) float64 { return float64(p.RSS) })
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	"golang.org/x/sys/windows/registry"
+)
+
+const checkinStatsFile = "checkin_stats.json"
+
+// metrics holds the counters the local prometheus exporter serves
+// Check-ins happen in the agent service so their counts are shared through a file in the check data dir
+var metrics = &agentMetrics{
+	checks:  make(map[int]*checkMetric),
+	scripts: make(map[[2]string]uint64),
+}
+
+type agentMetrics struct {
+	mu      sync.Mutex
+	checks  map[int]*checkMetric
+	scripts map[[2]string]uint64
+}
+
+type checkMetric struct {
+	Type     string
+	Status   string
+	Duration float64
+	LastRun  int64
+}
+
+type checkinStat struct {
+	Success uint64 `json:"success"`
+	Failure uint64 `json:"failure"`
+}
+
+var checkinStatsMu sync.Mutex
+
+func (m *agentMetrics) check(pk int) *checkMetric {
+	c, ok := m.checks[pk]
+	if !ok {
+		c = &checkMetric{}
+		m.checks[pk] = c
+	}
+	return c
+}
+
+func (m *agentMetrics) observeCheck(pk int, checkType string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.check(pk)
+	c.Type = checkType
+	c.Duration = duration.Seconds()
+	c.LastRun = time.Now().Unix()
+}
+
+func (m *agentMetrics) setCheckStatus(pk int, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.check(pk).Status = status
+}
+
+func (m *agentMetrics) countScript(shell string, exitcode int) {
+	result := "success"
+	if exitcode != 0 {
+		result = "failure"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scripts[[2]string{shell, result}]++
+}
+
+// countCheckIn records the result of a check-in for the exporter running in the rpc service
+func (a *WindowsAgent) countCheckIn(mode string, err error) {
+	checkinStatsMu.Lock()
+	defer checkinStatsMu.Unlock()
+
+	statsFile := a.CheckDataFile(checkinStatsFile)
+	stats := make(map[string]checkinStat)
+	_ = LoadJSON(statsFile, &stats)
+	s := stats[mode]
+	if err != nil {
+		s.Failure++
+	} else {
+		s.Success++
+	}
+	stats[mode] = s
+	if err := SaveJSON(statsFile, stats); err != nil {
+		a.Logger.Debugln("Checkin stats:", err)
+	}
+}
+
+// metricsConfig returns the exporter's listen address and allowed clients, the exporter is disabled if no address is set
+func (a *WindowsAgent) metricsConfig() (addr string, allowed []string) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\TacticalRMM`, registry.QUERY_VALUE)
+	if err != nil {
+		return "", nil
+	}
+	defer k.Close()
+
+	addr, _, _ = k.GetStringValue("MetricsListen")
+	allowed, _, _ = k.GetStringsValue("MetricsAllowed")
+	return
+}
+
+// ServeMetrics serves /metrics in the prometheus text format
+// Clients are limited to the allowed ips and cidrs, only loopback clients are allowed if none are set
+func (a *WindowsAgent) ServeMetrics(addr string, allowed []string, nc *nats.Conn) {
+	nets := make([]*net.IPNet, 0)
+	if len(allowed) == 0 {
+		a.Logger.Infoln("Metrics exporter has no allowed clients set, only allowing loopback")
+		allowed = []string{"127.0.0.0/8", "::1"}
+	}
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			a.Logger.Errorln("Metrics allowed client:", err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		if !metricsClientAllowed(req.RemoteAddr, nets) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(a.renderMetrics(nc)))
+	})
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	a.Logger.Infoln("Metrics exporter listening on", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		a.Logger.Errorln("Metrics exporter:", err)
+	}
+}
+
+func metricsClientAllowed(remote string, nets []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) header(name, help, kind string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample with labels given as name, value pairs
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabel(labels[i+1])))
+		}
+		fmt.Fprintf(&w.b, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(&w.b, " %g\n", value)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (a *WindowsAgent) renderMetrics(nc *nats.Conn) string {
+	w := &metricsWriter{}
+
+	if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 {
+		w.header("trmm_cpu_percent", "CPU usage since the previous scrape.", "gauge")
+		w.sample("trmm_cpu_percent", percent[0])
+	}
+
+	if vm, err := mem.VirtualMemory(); err == nil {
+		w.header("trmm_memory_total_bytes", "Total physical memory.", "gauge")
+		w.sample("trmm_memory_total_bytes", float64(vm.Total))
+		w.header("trmm_memory_available_bytes", "Available physical memory.", "gauge")
+		w.sample("trmm_memory_available_bytes", float64(vm.Available))
+	}
+
+	disks := a.GetDisks()
+	w.header("trmm_disk_total_bytes", "Total size of the fixed disk.", "gauge")
+	for _, d := range disks {
+		w.sample("trmm_disk_total_bytes", float64(d.Total), "device", d.Device)
+	}
+	w.header("trmm_disk_free_bytes", "Free space on the fixed disk.", "gauge")
+	for _, d := range disks {
+		w.sample("trmm_disk_free_bytes", float64(d.Free), "device", d.Device)
+	}
+
+	w.header("trmm_service_running", "Whether the windows service is running.", "gauge")
+	for _, s := range a.GetServices() {
+		w.sample("trmm_service_running", boolFloat(s.Status == "running"), "name", s.Name, "start_type", s.StartType)
+	}
+
+	metrics.mu.Lock()
+	pks := make([]int, 0, len(metrics.checks))
+	for pk := range metrics.checks {
+		pks = append(pks, pk)
+	}
+	sort.Ints(pks)
+	w.header("trmm_check_status", "Latest status of the check, 1 for the current status.", "gauge")
+	for _, pk := range pks {
+		c := metrics.checks[pk]
+		for _, status := range []string{"passing", "warning", "failing", "unknown", "suppressed"} {
+			w.sample("trmm_check_status", boolFloat(c.Status == status), "id", fmt.Sprint(pk), "type", c.Type, "status", status)
+		}
+	}
+	w.header("trmm_check_duration_seconds", "Duration of the check's latest run.", "gauge")
+	for _, pk := range pks {
+		c := metrics.checks[pk]
+		w.sample("trmm_check_duration_seconds", c.Duration, "id", fmt.Sprint(pk), "type", c.Type)
+	}
+	w.header("trmm_check_last_run_timestamp_seconds", "Time of the check's latest run.", "gauge")
+	for _, pk := range pks {
+		c := metrics.checks[pk]
+		w.sample("trmm_check_last_run_timestamp_seconds", float64(c.LastRun), "id", fmt.Sprint(pk), "type", c.Type)
+	}
+
+	w.header("trmm_script_runs_total", "Scripts run by the rpc service.", "counter")
+	keys := make([][2]string, 0, len(metrics.scripts))
+	for k := range metrics.scripts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1] })
+	for _, k := range keys {
+		w.sample("trmm_script_runs_total", float64(metrics.scripts[k]), "shell", k[0], "result", k[1])
+	}
+	metrics.mu.Unlock()
+
+	checkinStatsMu.Lock()
+	stats := make(map[string]checkinStat)
+	_ = LoadJSON(a.CheckDataFile(checkinStatsFile), &stats)
+	checkinStatsMu.Unlock()
+	modes := make([]string, 0, len(stats))
+	for mode := range stats {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	w.header("trmm_checkins_total", "Check-ins sent by the agent service.", "counter")
+	for _, mode := range modes {
+		w.sample("trmm_checkins_total", float64(stats[mode].Success), "mode", mode, "result", "success")
+		w.sample("trmm_checkins_total", float64(stats[mode].Failure), "mode", mode, "result", "failure")
+	}
+
+	w.header("trmm_nats_connected", "Whether the rpc service is connected to nats.", "gauge")
+	w.sample("trmm_nats_connected", boolFloat(nc != nil && nc.IsConnected()))
+
+	return w.b.String()
+}
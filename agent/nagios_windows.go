@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// runKillTree reports a timeout with this exit code, plugins only ever exit 0-3
+const timedOutExitCode = 98
+
+// PerfData is a single metric from the perfdata section of a plugin's output
+type PerfData struct {
+	Label string   `json:"label"`
+	Value float64  `json:"value"`
+	Unit  string   `json:"unit"`
+	Warn  string   `json:"warn,omitempty"`
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// NagiosCheck runs a nagios compatible plugin and maps its exit code and perfdata to a check result
+func (a *WindowsAgent) NagiosCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 60
+	}
+
+	start := time.Now()
+	stdout, stderr, exitcode, _ := a.runKillTree(data.Command, data.ScriptArgs, timeout)
+
+	var status string
+	switch exitcode {
+	case 0:
+		status = "passing"
+	case 1:
+		status = "warning"
+	case 2, timedOutExitCode:
+		status = "failing"
+	default:
+		status = "unknown"
+	}
+
+	text, perf := parseNagiosOutput(stdout)
+	if text == "" {
+		text = strings.TrimSpace(stderr)
+	}
+
+	payload := map[string]interface{}{
+		"id":       data.CheckPK,
+		"status":   status,
+		"retcode":  exitcode,
+		"output":   text,
+		"stderr":   stderr,
+		"perfdata": perf,
+		"runtime":  time.Since(start).Seconds(),
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// parseNagiosOutput splits plugin output into its text and perfdata
+// Perfdata follows a | on the first line, and on a later line for plugins with long output
+func parseNagiosOutput(out string) (string, []PerfData) {
+	var (
+		text     = make([]string, 0)
+		perfText = make([]string, 0)
+		inPerf   bool
+	)
+	lines := strings.Split(strings.ReplaceAll(out, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if inPerf {
+			perfText = append(perfText, line)
+			continue
+		}
+		if idx := strings.Index(line, "|"); idx >= 0 {
+			text = append(text, strings.TrimSpace(line[:idx]))
+			perfText = append(perfText, line[idx+1:])
+			// on the first line the perfdata ends with the line, after that it runs to the end
+			inPerf = i > 0
+			continue
+		}
+		text = append(text, line)
+	}
+
+	perf := make([]PerfData, 0)
+	for _, item := range splitPerfData(strings.Join(perfText, " ")) {
+		if m, ok := parsePerfItem(item); ok {
+			perf = append(perf, m)
+		}
+	}
+	return strings.TrimSpace(strings.Join(text, "\n")), perf
+}
+
+// splitPerfData splits on whitespace, keeping quoted labels which may contain spaces together
+func splitPerfData(s string) []string {
+	ret := make([]string, 0)
+	var (
+		cur    strings.Builder
+		quoted bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			cur.WriteRune(c)
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			if cur.Len() > 0 {
+				ret = append(ret, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	if cur.Len() > 0 {
+		ret = append(ret, cur.String())
+	}
+	return ret
+}
+
+// parsePerfItem parses 'label'=value[UOM];[warn];[crit];[min];[max]
+func parsePerfItem(item string) (PerfData, bool) {
+	m := PerfData{}
+	eq := strings.LastIndex(item, "=")
+	if eq <= 0 {
+		return m, false
+	}
+	m.Label = strings.ReplaceAll(strings.Trim(item[:eq], "'"), "''", "'")
+
+	fields := strings.Split(item[eq+1:], ";")
+	value := fields[0]
+	end := len(value)
+	for end > 0 && !strings.ContainsRune("0123456789.", rune(value[end-1])) {
+		end--
+	}
+	v, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return m, false
+	}
+	m.Value = v
+	m.Unit = value[end:]
+
+	if len(fields) > 1 {
+		m.Warn = fields[1]
+	}
+	if len(fields) > 2 {
+		m.Crit = fields[2]
+	}
+	if len(fields) > 3 {
+		if f, err := strconv.ParseFloat(fields[3], 64); err == nil {
+			m.Min = &f
+		}
+	}
+	if len(fields) > 4 {
+		if f, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			m.Max = &f
+		}
+	}
+	return m, true
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/go-resty/resty/v2"
+	psnet "github.com/shirou/gopsutil/v3/net"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const defaultNetIfSampleSeconds = 10
+
+// NetIfStats is the throughput and error rates of an interface over the sample window
+type NetIfStats struct {
+	Name         string  `json:"name"`
+	Up           bool    `json:"up"`
+	SpeedMbps    uint64  `json:"speed_mbps"`
+	RxMbps       float64 `json:"rx_mbps"`
+	TxMbps       float64 `json:"tx_mbps"`
+	RxPackets    uint64  `json:"rx_packets"`
+	TxPackets    uint64  `json:"tx_packets"`
+	Errors       uint64  `json:"errors"`
+	Drops        uint64  `json:"drops"`
+	ErrorPercent float64 `json:"error_percent"`
+}
+
+type netIfLink struct {
+	Up        bool   `json:"up"`
+	SpeedMbps uint64 `json:"speed_mbps"`
+}
+
+type win32NetworkAdapter struct {
+	NetConnectionID     string
+	NetConnectionStatus uint16
+	Speed               uint64
+}
+
+// NetIfCheck samples the interface counters over a window and checks throughput, error rates and link changes
+func (a *WindowsAgent) NetIfCheck(data rmm.Check, r *resty.Client) {
+	window := data.SampleSeconds
+	if window <= 0 {
+		window = defaultNetIfSampleSeconds
+	} else if window > maxCPUSampleSeconds {
+		window = maxCPUSampleSeconds
+	}
+
+	stateFile := a.CheckDataFile(fmt.Sprintf("netif_%d.json", data.CheckPK))
+	state := make(map[string]netIfLink)
+	_ = LoadJSON(stateFile, &state)
+
+	links := netIfLinks()
+	wanted := func(name string) bool {
+		if len(data.Interfaces) == 0 {
+			// connected adapters, plus any seen connected before so they are reported when the link drops
+			// disconnected and disabled adapters are skipped so spare ports and unused wifi don't fail the check
+			_, tracked := state[name]
+			return links[name].Up || tracked
+		}
+		for _, i := range data.Interfaces {
+			if strings.EqualFold(i, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	before, err := psnet.IOCounters(true)
+	if err != nil {
+		payload := map[string]interface{}{
+			"id":     data.CheckPK,
+			"status": "failing",
+			"output": err.Error(),
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+		return
+	}
+	start := time.Now()
+	time.Sleep(time.Duration(window) * time.Second)
+	after, _ := psnet.IOCounters(true)
+	elapsed := time.Since(start).Seconds()
+
+	prev := make(map[string]psnet.IOCountersStat)
+	for _, c := range before {
+		prev[c.Name] = c
+	}
+
+	newState := make(map[string]netIfLink)
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+		warnings = make([]string, 0)
+		stats    = make([]NetIfStats, 0)
+		seen     = make(map[string]bool)
+	)
+
+	for _, c := range after {
+		p, ok := prev[c.Name]
+		if !ok || !wanted(c.Name) {
+			continue
+		}
+		seen[strings.ToLower(c.Name)] = true
+
+		link, known := links[c.Name]
+		if !known {
+			// virtual and loopback interfaces have no adapter, treat them as always up
+			link.Up = true
+		}
+		s := NetIfStats{
+			Name:      c.Name,
+			Up:        link.Up,
+			SpeedMbps: link.SpeedMbps,
+			RxMbps:    math.Round(float64(counterDelta(c.BytesRecv, p.BytesRecv))*8/elapsed/1e4) / 100,
+			TxMbps:    math.Round(float64(counterDelta(c.BytesSent, p.BytesSent))*8/elapsed/1e4) / 100,
+			RxPackets: counterDelta(c.PacketsRecv, p.PacketsRecv),
+			TxPackets: counterDelta(c.PacketsSent, p.PacketsSent),
+			Errors:    counterDelta(c.Errin, p.Errin) + counterDelta(c.Errout, p.Errout),
+			Drops:     counterDelta(c.Dropin, p.Dropin) + counterDelta(c.Dropout, p.Dropout),
+		}
+		if packets := s.RxPackets + s.TxPackets; packets > 0 {
+			s.ErrorPercent = math.Round(float64(s.Errors+s.Drops)/float64(packets)*10000) / 100
+		}
+		stats = append(stats, s)
+		// only links that have been up are remembered, so a link that never came up isn't tracked as down
+		if _, tracked := state[c.Name]; link.Up || tracked {
+			newState[c.Name] = link
+		}
+
+		if !link.Up {
+			failures = append(failures, fmt.Sprintf("%s link down", c.Name))
+		} else if old, ok := state[c.Name]; ok && !old.Up {
+			warnings = append(warnings, fmt.Sprintf("%s link came back up", c.Name))
+		}
+		if old, ok := state[c.Name]; ok && link.Up && old.Up && old.SpeedMbps != link.SpeedMbps {
+			warnings = append(warnings, fmt.Sprintf("%s speed changed from %d to %d Mbps", c.Name, old.SpeedMbps, link.SpeedMbps))
+		}
+		if data.MaxMbps > 0 && math.Max(s.RxMbps, s.TxMbps) > data.MaxMbps {
+			failures = append(failures, fmt.Sprintf("%s throughput rx %.2f / tx %.2f Mbps exceeds %.2f Mbps", c.Name, s.RxMbps, s.TxMbps, data.MaxMbps))
+		}
+		if data.MaxErrorPercent > 0 && s.ErrorPercent > data.MaxErrorPercent {
+			failures = append(failures, fmt.Sprintf("%s error rate %.2f%% exceeds %.2f%%", c.Name, s.ErrorPercent, data.MaxErrorPercent))
+		}
+	}
+
+	for _, i := range data.Interfaces {
+		if !seen[strings.ToLower(i)] {
+			failures = append(failures, fmt.Sprintf("%s not found", i))
+		}
+	}
+
+	if err := SaveJSON(stateFile, newState); err != nil {
+		a.Logger.Debugln("Netif check:", err)
+	}
+
+	if len(failures) > 0 {
+		status = "failing"
+	} else if len(warnings) > 0 {
+		status = "warning"
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	output := fmt.Sprintf("%d interfaces sampled over %d seconds", len(stats), window)
+	if msgs := append(failures, warnings...); len(msgs) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(msgs, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":         data.CheckPK,
+		"status":     status,
+		"interfaces": stats,
+		"output":     output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// counterDelta returns the increase between two samples, a counter that went backwards was reset so only the new value counts
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// netIfLinks returns the link state and speed of each adapter keyed by connection name
+func netIfLinks() map[string]netIfLink {
+	ret := make(map[string]netIfLink)
+	var dst []win32NetworkAdapter
+	q := "SELECT NetConnectionID, NetConnectionStatus, Speed FROM Win32_NetworkAdapter WHERE NetConnectionID IS NOT NULL"
+	if err := wmi.Query(q, &dst); err != nil {
+		return ret
+	}
+	for _, n := range dst {
+		// 2 is connected
+		up := n.NetConnectionStatus == 2
+		var speed uint64
+		if up {
+			speed = n.Speed / 1000000
+		}
+		ret[n.NetConnectionID] = netIfLink{Up: up, SpeedMbps: speed}
+	}
+	return ret
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/windows"
+)
+
+// PingStats holds the results of a series of echo requests
+type PingStats struct {
+	Addr     string  `json:"addr"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss_percent"`
+	MinMS    float64 `json:"min_ms"`
+	AvgMS    float64 `json:"avg_ms"`
+	MaxMS    float64 `json:"max_ms"`
+}
+
+var (
+	modiphlpapi = windows.NewLazySystemDLL("iphlpapi.dll")
+
+	procIcmpCreateFile  = modiphlpapi.NewProc("IcmpCreateFile")
+	procIcmp6CreateFile = modiphlpapi.NewProc("Icmp6CreateFile")
+	procIcmpCloseHandle = modiphlpapi.NewProc("IcmpCloseHandle")
+	procIcmpSendEcho2   = modiphlpapi.NewProc("IcmpSendEcho2")
+	procIcmp6SendEcho2  = modiphlpapi.NewProc("Icmp6SendEcho2")
+)
+
+const (
+	// https://docs.microsoft.com/en-us/windows/win32/api/ipexport/ns-ipexport-icmp_echo_reply
+	icmpEchoReplyStatus = 4
+	// ICMPV6_ECHO_REPLY starts with a packed 26 byte IPV6_ADDRESS_EX
+	icmp6EchoReplyStatus = 28
+	ipStatusBase         = 11000
+	ipStatusMax          = 11999
+)
+
+var pingData = []byte("TacticalRMM")
+
+// sockaddrIn6 is the winsock SOCKADDR_IN6 used by Icmp6SendEcho2
+type sockaddrIn6 struct {
+	Family   uint16
+	Port     uint16
+	Flowinfo uint32
+	Addr     [16]byte
+	ScopeID  uint32
+}
+
+// NativePing sends count icmp echo requests to host
+// A raw icmp socket is used when permitted, otherwise the requests go through the windows icmp helper api which needs no privileges
+func NativePing(host string, count int, interval, timeout time.Duration) (PingStats, error) {
+	stats := PingStats{}
+	ipAddr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return stats, err
+	}
+	stats.Addr = ipAddr.String()
+
+	rawNet, listen := "ip4:icmp", "0.0.0.0"
+	if ipAddr.IP.To4() == nil {
+		rawNet, listen = "ip6:ipv6-icmp", "::"
+	}
+
+	var rtts []float64
+	if conn, lerr := icmp.ListenPacket(rawNet, listen); lerr == nil {
+		rtts, err = rawPing(conn, ipAddr, count, interval, timeout, &stats)
+		conn.Close()
+	} else {
+		rtts, err = icmpAPIPing(ipAddr, count, interval, timeout, &stats)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Received = len(rtts)
+	if stats.Sent > 0 {
+		stats.Loss = float64(stats.Sent-stats.Received) / float64(stats.Sent) * 100
+	}
+	if len(rtts) == 0 {
+		return stats, errors.New("request timed out")
+	}
+
+	stats.MinMS = math.MaxFloat64
+	var sum float64
+	for _, rtt := range rtts {
+		sum += rtt
+		stats.MinMS = math.Min(stats.MinMS, rtt)
+		stats.MaxMS = math.Max(stats.MaxMS, rtt)
+	}
+	stats.AvgMS = sum / float64(len(rtts))
+	return stats, nil
+}
+
+// rawPing sends the echo requests over a raw icmp socket and returns the round trip time of each reply
+func rawPing(conn *icmp.PacketConn, dst *net.IPAddr, count int, interval, timeout time.Duration, stats *PingStats) ([]float64, error) {
+	var (
+		proto              = 1
+		echoType icmp.Type = ipv4.ICMPTypeEcho
+	)
+	if dst.IP.To4() == nil {
+		proto, echoType = 58, ipv6.ICMPTypeEchoRequest
+	}
+
+	id := rand.Intn(0xffff)
+	rtts := make([]float64, 0, count)
+	buf := make([]byte, 1500)
+
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			time.Sleep(interval)
+		}
+
+		msg := icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: pingData},
+		}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return rtts, err
+		}
+
+		start := time.Now()
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return rtts, err
+		}
+		stats.Sent++
+
+		conn.SetReadDeadline(start.Add(timeout))
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				// timed out, count as lost
+				break
+			}
+			reply, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok || reply.Type == echoType || echo.ID != id || echo.Seq != seq {
+				continue
+			}
+			rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+			break
+		}
+	}
+	return rtts, nil
+}
+
+// icmpAPIPing sends the echo requests with IcmpSendEcho2 or Icmp6SendEcho2 and returns the round trip time of each reply
+func icmpAPIPing(dst *net.IPAddr, count int, interval, timeout time.Duration, stats *PingStats) ([]float64, error) {
+	ip4 := dst.IP.To4()
+	create, statusOffset := procIcmpCreateFile, icmpEchoReplyStatus
+	if ip4 == nil {
+		create, statusOffset = procIcmp6CreateFile, icmp6EchoReplyStatus
+	}
+
+	h, _, err := create.Call()
+	if windows.Handle(h) == windows.InvalidHandle {
+		return nil, fmt.Errorf("icmp create file: %v", err)
+	}
+	defer procIcmpCloseHandle.Call(h)
+
+	var src, dst6 sockaddrIn6
+	if ip4 == nil {
+		src.Family = windows.AF_INET6
+		dst6.Family = windows.AF_INET6
+		copy(dst6.Addr[:], dst.IP.To16())
+		if dst.Zone != "" {
+			if iface, err := net.InterfaceByName(dst.Zone); err == nil {
+				dst6.ScopeID = uint32(iface.Index)
+			}
+		}
+	}
+
+	rtts := make([]float64, 0, count)
+	reply := make([]byte, 1500)
+	ms := uintptr(timeout / time.Millisecond)
+
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			time.Sleep(interval)
+		}
+
+		// no event or apc routine, so the call blocks until a reply arrives or the timeout expires
+		start := time.Now()
+		var n uintptr
+		if ip4 != nil {
+			n, _, err = procIcmpSendEcho2.Call(h, 0, 0, 0,
+				uintptr(*(*uint32)(unsafe.Pointer(&ip4[0]))),
+				uintptr(unsafe.Pointer(&pingData[0])), uintptr(len(pingData)), 0,
+				uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)), ms)
+		} else {
+			n, _, err = procIcmp6SendEcho2.Call(h, 0, 0, 0,
+				uintptr(unsafe.Pointer(&src)), uintptr(unsafe.Pointer(&dst6)),
+				uintptr(unsafe.Pointer(&pingData[0])), uintptr(len(pingData)), 0,
+				uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)), ms)
+		}
+		elapsed := time.Since(start)
+
+		if n == 0 {
+			// ip status codes like timed out or unreachable are lost replies, anything else means the request wasn't sent
+			if errno, ok := err.(syscall.Errno); !ok || errno < ipStatusBase || errno > ipStatusMax {
+				return rtts, fmt.Errorf("icmp send echo: %v", err)
+			}
+			stats.Sent++
+			continue
+		}
+		stats.Sent++
+		if binary.LittleEndian.Uint32(reply[statusOffset:]) != 0 {
+			continue
+		}
+		rtts = append(rtts, float64(elapsed.Microseconds())/1000)
+	}
+	return rtts, nil
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	gops "github.com/shirou/gopsutil/v3/process"
+	rmm "github.com/wh1te909/rmmagent/shared"
+	"golang.org/x/sys/windows"
+)
+
+// MatchedProcess is a process matched by a process check
+type MatchedProcess struct {
+	Pid      int32   `json:"pid"`
+	Name     string  `json:"name"`
+	Username string  `json:"username"`
+	Cmdline  string  `json:"cmdline"`
+	CPU      float64 `json:"cpu_percent"`
+	RSS      uint64  `json:"rss"`
+}
+
+type procCheckState struct {
+	CPUOver int `json:"cpu_over"`
+	RSSOver int `json:"rss_over"`
+}
+
+// ProcessCheck counts processes matching name, command line and user, and optionally checks their cpu and memory usage
+func (a *WindowsAgent) ProcessCheck(data rmm.Check, r *resty.Client) {
+	var cmdRe *regexp.Regexp
+	if data.CmdlineRegex != "" {
+		re, err := regexp.Compile(data.CmdlineRegex)
+		if err != nil {
+			payload := map[string]interface{}{
+				"id":     data.CheckPK,
+				"status": "failing",
+				"output": fmt.Sprintf("Invalid command line regex: %v", err),
+			}
+			a.SendCheckResult(payload, data.AssignedTasks, r)
+			return
+		}
+		cmdRe = re
+	}
+
+	procs, err := gops.Processes()
+	if err != nil {
+		a.Logger.Debugln("Process check:", err)
+	}
+
+	matched := make([]*gops.Process, 0)
+	for _, p := range procs {
+		if data.ProcessName != "" {
+			name, err := p.Name()
+			if err != nil || !strings.EqualFold(name, data.ProcessName) {
+				continue
+			}
+		}
+		if data.ProcessUser != "" {
+			user, err := p.Username()
+			if err != nil || !strings.EqualFold(user, data.ProcessUser) && !strings.HasSuffix(strings.ToLower(user), `\`+strings.ToLower(data.ProcessUser)) {
+				continue
+			}
+		}
+		if cmdRe != nil {
+			cmdline, err := p.Cmdline()
+			if err != nil || !cmdRe.MatchString(cmdline) {
+				continue
+			}
+		}
+		matched = append(matched, p)
+	}
+
+	// the first call only primes the counters, the second returns usage over the sample period
+	for _, p := range matched {
+		p.Percent(0)
+	}
+	time.Sleep(2 * time.Second)
+
+	var totalCPU float64
+	var totalRSS uint64
+	results := make([]MatchedProcess, 0)
+	for _, p := range matched {
+		cpu, err := p.Percent(0)
+		if err != nil {
+			// process exited during the sample
+			continue
+		}
+		name, _ := p.Name()
+		user, _ := p.Username()
+		cmdline, _ := p.Cmdline()
+		var rss uint64
+		if mem, err := p.MemoryInfo(); err == nil {
+			rss = mem.RSS
+		}
+		totalCPU += cpu
+		totalRSS += rss
+		results = append(results, MatchedProcess{
+			Pid:      p.Pid,
+			Name:     name,
+			Username: user,
+			Cmdline:  cmdline,
+			CPU:      cpu,
+			RSS:      rss,
+		})
+	}
+
+	// with neither count set the process just has to be running
+	minCount := data.MinCount
+	if minCount == 0 && data.MaxCount == nil {
+		minCount = 1
+	}
+
+	failures := make([]string, 0)
+	count := len(results)
+	if count < minCount {
+		failures = append(failures, fmt.Sprintf("%d running, expected at least %d", count, minCount))
+	}
+	if data.MaxCount != nil && count > *data.MaxCount {
+		failures = append(failures, fmt.Sprintf("%d running, expected at most %d", count, *data.MaxCount))
+	}
+
+	samples := data.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+	stateFile := a.CheckDataFile(fmt.Sprintf("process_%d.json", data.CheckPK))
+	state := procCheckState{}
+	_ = LoadJSON(stateFile, &state)
+
+	if data.CPUThreshold > 0 && totalCPU > data.CPUThreshold {
+		state.CPUOver++
+	} else {
+		state.CPUOver = 0
+	}
+	if data.RSSThresholdMB > 0 && totalRSS > uint64(data.RSSThresholdMB)*1024*1024 {
+		state.RSSOver++
+	} else {
+		state.RSSOver = 0
+	}
+	if err := SaveJSON(stateFile, state); err != nil {
+		a.Logger.Debugln("Process check:", err)
+	}
+
+	if state.CPUOver >= samples {
+		failures = append(failures, fmt.Sprintf("cpu %.1f%% above %.1f%% for %d samples", totalCPU, data.CPUThreshold, state.CPUOver))
+	}
+	if state.RSSOver >= samples {
+		failures = append(failures, fmt.Sprintf("memory %s above %d MB for %d samples", ByteCountSI(totalRSS), data.RSSThresholdMB, state.RSSOver))
+	}
+
+	restarted := false
+	if count < minCount && data.RestartIfStopped && data.RestartCommand != "" {
+		a.Logger.Infoln("Process check restarting with command:", data.RestartCommand)
+		if err := startDetached(data.RestartCommand); err != nil {
+			failures = append(failures, fmt.Sprintf("restart failed: %v", err))
+		} else {
+			restarted = true
+		}
+	}
+
+	status := "passing"
+	output := fmt.Sprintf("%d matching processes, cpu %.1f%%, memory %s", count, totalCPU, ByteCountSI(totalRSS))
+	if len(failures) > 0 {
+		status = "failing"
+		output = fmt.Sprintf("%s: %s", output, strings.Join(failures, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":        data.CheckPK,
+		"status":    status,
+		"count":     count,
+		"cpu":       totalCPU,
+		"rss":       totalRSS,
+		"processes": results,
+		"restarted": restarted,
+		"output":    output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// startDetached runs a command through cmd.exe without waiting for it, so long running processes aren't killed
+func startDetached(command string) error {
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &windows.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+		CmdLine:       fmt.Sprintf("cmd.exe /C %s", command),
+	}
+	return cmd.Start()
+}
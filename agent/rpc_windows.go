@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
@@ -24,6 +25,8 @@ type NatsMsg struct {
 	UpdateGUIDs     []string          `json:"guids"`
 	ChocoProgName   string            `json:"choco_prog_name"`
 	PendingActionPK int               `json:"pending_action_pk"`
+	FileData        []byte            `json:"file_data"`
+	FileOffset      int64             `json:"file_offset"`
 }
 
 var (
@@ -41,8 +44,14 @@ func (a *WindowsAgent) RunRPC() {
 		a.Logger.Fatalln(err)
 	}
 
+	checkScheduler := a.NewCheckScheduler()
+	go checkScheduler.Run()
+
+	if addr, allowed := a.metricsConfig(); addr != "" {
+		go a.ServeMetrics(addr, allowed, nc)
+	}
+
 	nc.Subscribe(a.AgentID, func(msg *nats.Msg) {
-		a.Logger.SetOutput(os.Stdout)
 		var payload *NatsMsg
 		var mh codec.MsgpackHandle
 		mh.RawToString = true
@@ -156,7 +165,8 @@ func (a *WindowsAgent) RunRPC() {
 			go func(p *NatsMsg) {
 				var resp []byte
 				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
-				out, _ := CMDShell(p.Data["shell"], []string{}, p.Data["command"], p.Timeout, false)
+				out, cmdErr := CMDShell(p.Data["shell"], []string{}, p.Data["command"], p.Timeout, false)
+				a.AuditScript("rawcmd", p.Data["shell"], p.Data["command"], nil, cmdErr)
 				a.Logger.Debugln(out)
 				if out[1] != "" {
 					ret.Encode(out[1])
@@ -213,6 +223,7 @@ func (a *WindowsAgent) RunRPC() {
 				var retData string
 				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
 				stdout, stderr, _, err := a.RunScript(p.Data["code"], p.Data["shell"], p.ScriptArgs, p.Timeout)
+				a.AuditScript("runscript", p.Data["shell"], p.Data["code"], p.ScriptArgs, err)
 				if err != nil {
 					a.Logger.Debugln(err)
 					retData = err.Error()
@@ -229,7 +240,8 @@ func (a *WindowsAgent) RunRPC() {
 				var resp []byte
 				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
 				start := time.Now()
-				out, err, retcode, _ := a.RunScript(p.Data["code"], p.Data["shell"], p.ScriptArgs, p.Timeout)
+				out, err, retcode, runErr := a.RunScript(p.Data["code"], p.Data["shell"], p.ScriptArgs, p.Timeout)
+				a.AuditScript("runscriptfull", p.Data["shell"], p.Data["code"], p.ScriptArgs, runErr)
 				retData := struct {
 					Stdout   string  `json:"stdout"`
 					Stderr   string  `json:"stderr"`
@@ -241,6 +253,128 @@ func (a *WindowsAgent) RunRPC() {
 				msg.Respond(resp)
 			}(payload)
 
+		case "fileget":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				chunkSize, _ := strconv.Atoi(p.Data["chunksize"])
+				chunk := a.FileGet(p.Data["path"], p.FileOffset, chunkSize)
+				if chunk.Error != "" {
+					a.Logger.Debugln("fileget:", chunk.Error)
+				} else {
+					a.Logger.Debugf("fileget %s: %.1f%%", chunk.Path, chunk.Progress)
+				}
+				ret.Encode(chunk)
+				msg.Respond(resp)
+			}(payload)
+
+		case "fileput":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				size, _ := strconv.ParseInt(p.Data["size"], 10, 64)
+				status := a.FilePut(p.Data["path"], p.FileOffset, size, p.Data["sha256"], p.FileData)
+				if status.Error != "" {
+					a.Logger.Debugln("fileput:", status.Error)
+				} else {
+					a.Logger.Debugf("fileput %s: %.1f%%", status.Path, status.Progress)
+				}
+				ret.Encode(status)
+				msg.Respond(resp)
+			}(payload)
+
+		case "listdir":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				offset, _ := strconv.Atoi(p.Data["offset"])
+				limit, _ := strconv.Atoi(p.Data["limit"])
+				listing := a.ListDir(p.Data["path"], offset, limit)
+				ret.Encode(listing)
+				msg.Respond(resp)
+			}(payload)
+
+		case "statfile":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				entry, err := a.StatFile(p.Data["path"])
+				if err != nil {
+					a.Logger.Debugln(err)
+					ret.Encode(err.Error())
+				} else {
+					ret.Encode(entry)
+				}
+				msg.Respond(resp)
+			}(payload)
+
+		case "mkdir", "renamefile", "deletefile":
+			go func(p *NatsMsg) {
+				var resp []byte
+				var err error
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				switch p.Func {
+				case "mkdir":
+					err = a.MakeDir(p.Data["path"])
+				case "renamefile":
+					err = a.RenameFile(p.Data["path"], p.Data["newpath"])
+				case "deletefile":
+					err = a.DeleteFile(p.Data["path"], p.Data["recursive"] == "true")
+				}
+				if err != nil {
+					a.Logger.Debugln(err)
+					ret.Encode(err.Error())
+				} else {
+					ret.Encode("ok")
+				}
+				msg.Respond(resp)
+			}(payload)
+
+		case "tailfile":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				subject := p.Data["subject"]
+				lines, _ := strconv.Atoi(p.Data["lines"])
+				opts := TailOpts{
+					Path:    p.Data["path"],
+					Include: p.Data["include"],
+					Exclude: p.Data["exclude"],
+					Lines:   lines,
+					Timeout: p.Timeout,
+				}
+				var err error
+				if subject == "" {
+					err = errors.New("missing reply subject")
+				} else {
+					err = a.TailFile(subject, opts, func(m TailMsg) {
+						var b []byte
+						enc := codec.NewEncoderBytes(&b, new(codec.MsgpackHandle))
+						enc.Encode(m)
+						nc.Publish(subject, b)
+					})
+				}
+				if err != nil {
+					a.Logger.Debugln("tailfile:", err)
+					ret.Encode(err.Error())
+				} else {
+					ret.Encode("ok")
+				}
+				msg.Respond(resp)
+			}(payload)
+
+		case "canceltail":
+			go func(p *NatsMsg) {
+				var resp []byte
+				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
+				if CancelTail(p.Data["subject"]) {
+					ret.Encode("ok")
+				} else {
+					ret.Encode("tail not running")
+				}
+				msg.Respond(resp)
+			}(payload)
+
 		case "recover":
 			go func(p *NatsMsg) {
 				var resp []byte
@@ -343,19 +477,14 @@ func (a *WindowsAgent) RunRPC() {
 			go func() {
 				var resp []byte
 				ret := codec.NewEncoderBytes(&resp, new(codec.MsgpackHandle))
-				if a.ChecksRunning() {
+				if !checkScheduler.Trigger() {
 					ret.Encode("busy")
-					msg.Respond(resp)
 					a.Logger.Debugln("Checks are already running, please wait")
 				} else {
 					ret.Encode("ok")
-					msg.Respond(resp)
 					a.Logger.Debugln("Running checks")
-					_, checkerr := CMD(a.EXE, []string{"-m", "runchecks"}, 600, false)
-					if checkerr != nil {
-						a.Logger.Errorln("RPC RunChecks", checkerr)
-					}
 				}
+				msg.Respond(resp)
 			}()
 		case "runtask":
 			go func(p *NatsMsg) {
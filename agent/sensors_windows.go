@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/StackExchange/wmi"
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// SensorReading is a single thermal zone temperature reading
+type SensorReading struct {
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	Crit   float64 `json:"crit,omitempty"`
+	Status string  `json:"status"`
+}
+
+type msAcpiThermalZoneTemperature struct {
+	InstanceName       string
+	CurrentTemperature uint32
+	CriticalTripPoint  uint32
+}
+
+// SensorsCheck reads the hardware sensors and checks them against per sensor thresholds
+// Sensors without a matching threshold are checked against their critical trip point
+func (a *WindowsAgent) SensorsCheck(data rmm.Check, r *resty.Client) {
+	readings, err := thermalZones()
+	if err == nil && len(readings) == 0 {
+		err = fmt.Errorf("no thermal zones found")
+	}
+	if err != nil {
+		// many boards don't expose acpi thermal zones, that says nothing about the hardware so it isn't a failure
+		payload := map[string]interface{}{
+			"id":     data.CheckPK,
+			"status": "unknown",
+			"output": fmt.Sprintf("sensors not available: %s", err.Error()),
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+		return
+	}
+
+	var (
+		status   = "passing"
+		failures = make([]string, 0)
+		warnings = make([]string, 0)
+	)
+
+	for i := range readings {
+		s := &readings[i]
+		s.Status = sensorStatus(*s, data.SensorThresholds)
+		msg := fmt.Sprintf("%s %.1f %s", s.Name, s.Value, s.Unit)
+		switch s.Status {
+		case "failing":
+			failures = append(failures, msg)
+		case "warning":
+			warnings = append(warnings, msg)
+		}
+	}
+
+	if len(failures) > 0 {
+		status = "failing"
+	} else if len(warnings) > 0 {
+		status = "warning"
+	}
+
+	output := fmt.Sprintf("%d sensors read", len(readings))
+	if msgs := append(failures, warnings...); len(msgs) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(msgs, ", "))
+	}
+
+	payload := map[string]interface{}{
+		"id":      data.CheckPK,
+		"status":  status,
+		"sensors": readings,
+		"output":  output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// sensorStatus uses the first threshold whose glob matches the sensor name
+func sensorStatus(s SensorReading, thresholds []rmm.SensorThreshold) string {
+	for _, t := range thresholds {
+		if ok, _ := filepath.Match(strings.ToLower(t.Match), strings.ToLower(s.Name)); !ok {
+			continue
+		}
+		switch {
+		case t.FailAbove != 0 && s.Value >= t.FailAbove:
+			return "failing"
+		case t.FailBelow != 0 && s.Value <= t.FailBelow:
+			return "failing"
+		case t.WarnAbove != 0 && s.Value >= t.WarnAbove:
+			return "warning"
+		}
+		return "passing"
+	}
+
+	if s.Crit > 0 && s.Value >= s.Crit {
+		return "failing"
+	}
+	return "passing"
+}
+
+// thermalZones reads the acpi thermal zones, temperatures are reported by wmi in tenths of a kelvin
+func thermalZones() ([]SensorReading, error) {
+	ret := make([]SensorReading, 0)
+	var dst []msAcpiThermalZoneTemperature
+	q := "SELECT InstanceName, CurrentTemperature, CriticalTripPoint FROM MSAcpi_ThermalZoneTemperature"
+	if err := wmi.QueryNamespace(q, &dst, `root\WMI`); err != nil {
+		return ret, err
+	}
+
+	toCelsius := func(v uint32) float64 {
+		return math.Round((float64(v)/10-273.15)*10) / 10
+	}
+	for _, z := range dst {
+		s := SensorReading{
+			Name:  z.InstanceName,
+			Unit:  "C",
+			Value: toCelsius(z.CurrentTemperature),
+		}
+		if z.CriticalTripPoint > 0 {
+			s.Crit = toCelsius(z.CriticalTripPoint)
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SmartDisk is the health summary of a physical disk parsed from smartctl's json output
+type SmartDisk struct {
+	Device             string   `json:"device"`
+	Model              string   `json:"model"`
+	Serial             string   `json:"serial"`
+	Protocol           string   `json:"protocol"`
+	Passed             bool     `json:"passed"`
+	ReallocatedSectors int64    `json:"reallocated_sectors"`
+	PendingSectors     int64    `json:"pending_sectors"`
+	PowerOnHours       int64    `json:"power_on_hours"`
+	Temperature        int64    `json:"temperature"`
+	PercentageUsed     int64    `json:"percentage_used"`
+	CriticalWarning    int64    `json:"critical_warning"`
+	MediaErrors        int64    `json:"media_errors"`
+	FailingAttributes  []string `json:"failing_attributes"`
+	Status             string   `json:"status"`
+	Problems           []string `json:"problems"`
+}
+
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String   string `json:"string"`
+			Severity string `json:"severity"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Device struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	SmartStatus  *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	PowerOnTime struct {
+		Hours int64 `json:"hours"`
+	} `json:"power_on_time"`
+	Temperature struct {
+		Current int64 `json:"current"`
+	} `json:"temperature"`
+	AtaSmartAttributes struct {
+		Table []struct {
+			ID         int    `json:"id"`
+			Name       string `json:"name"`
+			WhenFailed string `json:"when_failed"`
+			Raw        struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NvmeHealth *struct {
+		CriticalWarning int64 `json:"critical_warning"`
+		PercentageUsed  int64 `json:"percentage_used"`
+		MediaErrors     int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+type smartctlScan struct {
+	Devices []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"devices"`
+}
+
+// smartctl exit status bits, see the smartctl man page
+const (
+	smartExitCmdLine    = 1 << 0
+	smartExitOpen       = 1 << 1
+	smartExitDiskFailed = 1 << 3
+	smartExitPrefail    = 1 << 4
+)
+
+// parseSmartctl parses the output of smartctl --json -a and evaluates the disk's health
+// Failing on predictive failure indicators and warning on early signs like remapped or pending sectors
+func parseSmartctl(b []byte) (SmartDisk, error) {
+	var out smartctlOutput
+	d := SmartDisk{FailingAttributes: make([]string, 0), Problems: make([]string, 0)}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return d, err
+	}
+
+	exit := out.Smartctl.ExitStatus
+	if exit&(smartExitCmdLine|smartExitOpen) != 0 {
+		msg := "smartctl failed"
+		if len(out.Smartctl.Messages) > 0 {
+			msg = out.Smartctl.Messages[0].String
+		}
+		return d, fmt.Errorf("%s: %s", out.Device.Name, msg)
+	}
+
+	d.Device = out.Device.Name
+	d.Protocol = out.Device.Protocol
+	d.Model = out.ModelName
+	d.Serial = out.SerialNumber
+	d.PowerOnHours = out.PowerOnTime.Hours
+	d.Temperature = out.Temperature.Current
+	d.Passed = out.SmartStatus == nil || out.SmartStatus.Passed
+
+	for _, attr := range out.AtaSmartAttributes.Table {
+		switch attr.ID {
+		case 5:
+			d.ReallocatedSectors = attr.Raw.Value
+		case 197:
+			d.PendingSectors = attr.Raw.Value
+		}
+		if attr.WhenFailed == "now" {
+			d.FailingAttributes = append(d.FailingAttributes, attr.Name)
+		}
+	}
+	if nvme := out.NvmeHealth; nvme != nil {
+		d.PercentageUsed = nvme.PercentageUsed
+		d.CriticalWarning = nvme.CriticalWarning
+		d.MediaErrors = nvme.MediaErrors
+	}
+
+	var failing, warning bool
+	if !d.Passed || exit&smartExitDiskFailed != 0 {
+		failing = true
+		d.Problems = append(d.Problems, "SMART overall health failed")
+	}
+	if len(d.FailingAttributes) > 0 || exit&smartExitPrefail != 0 {
+		failing = true
+		d.Problems = append(d.Problems, "prefail attributes at or below threshold")
+	}
+	if d.CriticalWarning != 0 {
+		failing = true
+		d.Problems = append(d.Problems, fmt.Sprintf("nvme critical warning 0x%x", d.CriticalWarning))
+	}
+	if d.MediaErrors > 0 {
+		// unrecovered data integrity errors, the nvme counterpart of uncorrectable sectors
+		failing = true
+		d.Problems = append(d.Problems, fmt.Sprintf("%d nvme media errors", d.MediaErrors))
+	}
+	if d.PercentageUsed >= 100 {
+		failing = true
+		d.Problems = append(d.Problems, fmt.Sprintf("%d%% of rated endurance used", d.PercentageUsed))
+	} else if d.PercentageUsed >= 90 {
+		warning = true
+		d.Problems = append(d.Problems, fmt.Sprintf("%d%% of rated endurance used", d.PercentageUsed))
+	}
+	if d.ReallocatedSectors > 0 {
+		warning = true
+		d.Problems = append(d.Problems, fmt.Sprintf("%d reallocated sectors", d.ReallocatedSectors))
+	}
+	if d.PendingSectors > 0 {
+		warning = true
+		d.Problems = append(d.Problems, fmt.Sprintf("%d pending sectors", d.PendingSectors))
+	}
+
+	switch {
+	case failing:
+		d.Status = "failing"
+	case warning:
+		d.Status = "warning"
+	default:
+		d.Status = "passing"
+	}
+	return d, nil
+}
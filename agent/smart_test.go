@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSmartctl(t *testing.T) {
+	tests := []struct {
+		file        string
+		status      string
+		reallocated int64
+		pending     int64
+		mediaErrors int64
+		failingAttr []string
+		problems    []string
+	}{
+		{
+			file:        "ata_healthy.json",
+			status:      "passing",
+			failingAttr: []string{},
+			problems:    []string{},
+		},
+		{
+			file:        "ata_reallocated.json",
+			status:      "warning",
+			reallocated: 24,
+			pending:     3,
+			failingAttr: []string{},
+			problems:    []string{"24 reallocated sectors", "3 pending sectors"},
+		},
+		{
+			file:        "ata_failing_now.json",
+			status:      "failing",
+			reallocated: 62472,
+			pending:     8,
+			failingAttr: []string{"Reallocated_Sector_Ct"},
+			problems: []string{
+				"SMART overall health failed",
+				"prefail attributes at or below threshold",
+				"62472 reallocated sectors",
+				"8 pending sectors",
+			},
+		},
+		{
+			file:        "nvme_critical_warning.json",
+			status:      "failing",
+			failingAttr: []string{},
+			problems:    []string{"SMART overall health failed", "nvme critical warning 0x1"},
+		},
+		{
+			file:        "nvme_media_errors.json",
+			status:      "failing",
+			mediaErrors: 37,
+			failingAttr: []string{},
+			problems:    []string{"37 nvme media errors"},
+		},
+		{
+			file:        "nvme_used_90.json",
+			status:      "warning",
+			failingAttr: []string{},
+			problems:    []string{"92% of rated endurance used"},
+		},
+		{
+			file:        "nvme_used_100.json",
+			status:      "failing",
+			failingAttr: []string{},
+			problems:    []string{"104% of rated endurance used"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			d, err := parseSmartctl(readSmartFixture(t, tt.file))
+			if err != nil {
+				t.Fatalf("parseSmartctl: %v", err)
+			}
+			if d.Status != tt.status {
+				t.Errorf("status = %q, want %q", d.Status, tt.status)
+			}
+			if d.ReallocatedSectors != tt.reallocated {
+				t.Errorf("reallocated = %d, want %d", d.ReallocatedSectors, tt.reallocated)
+			}
+			if d.PendingSectors != tt.pending {
+				t.Errorf("pending = %d, want %d", d.PendingSectors, tt.pending)
+			}
+			if d.MediaErrors != tt.mediaErrors {
+				t.Errorf("media errors = %d, want %d", d.MediaErrors, tt.mediaErrors)
+			}
+			if !reflect.DeepEqual(d.FailingAttributes, tt.failingAttr) {
+				t.Errorf("failing attributes = %q, want %q", d.FailingAttributes, tt.failingAttr)
+			}
+			if !reflect.DeepEqual(d.Problems, tt.problems) {
+				t.Errorf("problems = %q, want %q", d.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestParseSmartctlDeviceInfo(t *testing.T) {
+	d, err := parseSmartctl(readSmartFixture(t, "nvme_used_90.json"))
+	if err != nil {
+		t.Fatalf("parseSmartctl: %v", err)
+	}
+	want := SmartDisk{
+		Device:            "/dev/sda",
+		Model:             "Samsung SSD 970 EVO Plus 1TB",
+		Serial:            "S4EWNF0M712345X",
+		Protocol:          "NVMe",
+		Passed:            true,
+		PowerOnHours:      18320,
+		Temperature:       41,
+		PercentageUsed:    92,
+		FailingAttributes: []string{},
+		Status:            "warning",
+		Problems:          []string{"92% of rated endurance used"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("parseSmartctl = %+v, want %+v", d, want)
+	}
+}
+
+func TestParseSmartctlOpenFailed(t *testing.T) {
+	_, err := parseSmartctl(readSmartFixture(t, "open_failed.json"))
+	if err == nil {
+		t.Fatal("expected an error when smartctl can't open the device")
+	}
+	if !strings.Contains(err.Error(), "Unable to detect device type") {
+		t.Errorf("error = %q, want smartctl's message", err.Error())
+	}
+}
+
+func TestParseSmartctlInvalidJSON(t *testing.T) {
+	if _, err := parseSmartctl([]byte("smartctl: command not found")); err == nil {
+		t.Fatal("expected an error for output that isn't json")
+	}
+}
+
+func readSmartFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "smart", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+// SmartCheck reads the SMART health of every physical disk using smartctl
+func (a *WindowsAgent) SmartCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 60
+	}
+
+	smartctl := data.Command
+	if smartctl == "" {
+		smartctl = "smartctl.exe"
+		installed := filepath.Join(os.Getenv("ProgramFiles"), "smartmontools", "bin", "smartctl.exe")
+		if FileExists(installed) {
+			smartctl = installed
+		}
+	}
+
+	failCheck := func(output string) {
+		payload := map[string]interface{}{
+			"id":     data.CheckPK,
+			"status": "failing",
+			"output": output,
+		}
+		a.SendCheckResult(payload, data.AssignedTasks, r)
+	}
+
+	stdout, stderr, _, err := a.runKillTree(smartctl, []string{"--scan", "--json"}, timeout)
+	if err != nil {
+		failCheck(fmt.Sprintf("Unable to run smartctl: %v", err))
+		return
+	}
+	var scan smartctlScan
+	if err := json.Unmarshal([]byte(stdout), &scan); err != nil {
+		failCheck(fmt.Sprintf("Unable to parse smartctl scan: %v %s", err, stderr))
+		return
+	}
+	if len(scan.Devices) == 0 {
+		failCheck("No disks found by smartctl")
+		return
+	}
+
+	var (
+		status   = "passing"
+		disks    = make([]SmartDisk, 0)
+		problems = make([]string, 0)
+	)
+	for _, dev := range scan.Devices {
+		out, _, _, _ := a.runKillTree(smartctl, []string{"--json", "-a", "-d", dev.Type, dev.Name}, timeout)
+		d, err := parseSmartctl([]byte(out))
+		if err != nil {
+			a.Logger.Debugln("SMART check:", err)
+			problems = append(problems, err.Error())
+			if status == "passing" {
+				status = "warning"
+			}
+			continue
+		}
+		disks = append(disks, d)
+
+		if len(d.Problems) > 0 {
+			problems = append(problems, fmt.Sprintf("%s (%s): %s", d.Device, d.Model, strings.Join(d.Problems, ", ")))
+		}
+		switch {
+		case d.Status == "failing":
+			status = "failing"
+		case d.Status == "warning" && status == "passing":
+			status = "warning"
+		}
+	}
+
+	output := fmt.Sprintf("%d disks checked", len(disks))
+	if len(problems) > 0 {
+		output = fmt.Sprintf("%s: %s", output, strings.Join(problems, "; "))
+	}
+
+	payload := map[string]interface{}{
+		"id":     data.CheckPK,
+		"status": status,
+		"disks":  disks,
+		"output": output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
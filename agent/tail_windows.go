@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	tailPollInterval   = 500 * time.Millisecond
+	defaultTailTimeout = 300
+	maxTailTimeout     = 3600
+	maxTailLineLength  = 64 * 1024
+)
+
+// active tails keyed by their reply subject so they can be cancelled
+var tailCancels sync.Map
+
+type tailHandle struct {
+	cancel context.CancelFunc
+}
+
+// TailMsg is published to the reply subject for every batch of new lines
+type TailMsg struct {
+	Lines []string `json:"lines"`
+	Done  bool     `json:"done"`
+	Error string   `json:"error"`
+}
+
+// TailOpts configures a log tail
+type TailOpts struct {
+	Path    string
+	Include string
+	Exclude string
+	Lines   int
+	Timeout int
+}
+
+// TailFile follows a file like tail -F and sends new lines to publish until the timeout is reached or the tail is cancelled
+// Rotated files are reopened from the start and truncated files are read again from the beginning
+func (a *WindowsAgent) TailFile(id string, opts TailOpts, publish func(TailMsg)) error {
+	path, err := a.PathAllowed(opts.Path)
+	a.AuditLog("tailfile", opts.Path, err)
+	if err != nil {
+		return err
+	}
+
+	var include, exclude *regexp.Regexp
+	if opts.Include != "" {
+		if include, err = regexp.Compile(opts.Include); err != nil {
+			return fmt.Errorf("include regex: %v", err)
+		}
+	}
+	if opts.Exclude != "" {
+		if exclude, err = regexp.Compile(opts.Exclude); err != nil {
+			return fmt.Errorf("exclude regex: %v", err)
+		}
+	}
+
+	f, err := openShared(path)
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTailTimeout
+	} else if timeout > maxTailTimeout {
+		timeout = maxTailTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	handle := &tailHandle{cancel: cancel}
+	if prev, loaded := tailCancels.LoadOrStore(id, handle); loaded {
+		prev.(*tailHandle).cancel()
+		tailCancels.Store(id, handle)
+	}
+
+	go func() {
+		t := &logTail{path: path, f: f, include: include, exclude: exclude}
+		defer func() {
+			t.f.Close()
+			cancel()
+			if cur, ok := tailCancels.Load(id); ok && cur.(*tailHandle) == handle {
+				tailCancels.Delete(id)
+			}
+		}()
+		if err := t.start(opts.Lines); err != nil {
+			publish(TailMsg{Done: true, Error: err.Error()})
+			return
+		}
+
+		ticker := time.NewTicker(tailPollInterval)
+		defer ticker.Stop()
+
+		for {
+			lines, err := t.poll()
+			if len(lines) > 0 {
+				publish(TailMsg{Lines: lines})
+			}
+			if err != nil {
+				a.Logger.Debugln("tailfile:", err)
+				publish(TailMsg{Done: true, Error: err.Error()})
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				publish(TailMsg{Done: true})
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// CancelTail stops a running tail
+func CancelTail(id string) bool {
+	handle, ok := tailCancels.Load(id)
+	if !ok {
+		return false
+	}
+	handle.(*tailHandle).cancel()
+	return true
+}
+
+// openShared opens a file for reading without locking it, so the application
+// writing the log can still rotate or delete it while we have it open
+func openShared(path string) (*os.File, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(p, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+type logTail struct {
+	path    string
+	f       *os.File
+	offset  int64
+	partial []byte
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// start positions the tail at the end of the file, going back far enough to include the last n lines
+func (t *logTail) start(n int) error {
+	fi, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	t.offset = fi.Size()
+	if n <= 0 || t.offset == 0 {
+		return nil
+	}
+
+	back := int64(n * 512)
+	if back > t.offset {
+		back = t.offset
+	}
+	buf := make([]byte, back)
+	if _, err := t.f.ReadAt(buf, t.offset-back); err != nil && err != io.EOF {
+		return err
+	}
+
+	// walk backwards to the start of the nth line from the end, ignoring the final newline
+	end := len(buf)
+	if end > 0 && buf[end-1] == '\n' {
+		end--
+	}
+	pos, found := 0, 0
+	for i := end - 1; i >= 0; i-- {
+		if buf[i] == '\n' {
+			found++
+			if found == n {
+				pos = i + 1
+				break
+			}
+		}
+	}
+	// the first line is most likely cut in half unless we read the whole file
+	if found < n && back < t.offset {
+		if idx := bytes.IndexByte(buf[:end], '\n'); idx >= 0 {
+			pos = idx + 1
+		}
+	}
+	t.offset = t.offset - back + int64(pos)
+	return nil
+}
+
+// poll returns any complete lines written since the last poll
+func (t *logTail) poll() ([]string, error) {
+	fi, err := os.Stat(t.path)
+	if err == nil {
+		cur, serr := t.f.Stat()
+		if serr == nil && !os.SameFile(fi, cur) {
+			// rotated, drain whatever is left in the old file then switch to the new one
+			lines, _ := t.read()
+			if len(t.partial) > 0 {
+				lines = t.appendLine(lines, t.partial)
+				t.partial = nil
+			}
+			nf, oerr := openShared(t.path)
+			if oerr != nil {
+				return lines, nil
+			}
+			t.f.Close()
+			t.f = nf
+			t.offset = 0
+			t.partial = nil
+			more, rerr := t.read()
+			return append(lines, more...), rerr
+		}
+	}
+
+	cur, err := t.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if cur.Size() < t.offset {
+		// truncated
+		t.offset = 0
+		t.partial = nil
+	}
+	return t.read()
+}
+
+func (t *logTail) read() ([]string, error) {
+	ret := make([]string, 0)
+	if _, err := t.f.Seek(t.offset, io.SeekStart); err != nil {
+		return ret, err
+	}
+
+	reader := bufio.NewReader(t.f)
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		t.offset += int64(len(chunk))
+		if err != nil {
+			// keep incomplete lines until the rest is written
+			t.partial = append(t.partial, chunk...)
+			if len(t.partial) > maxTailLineLength {
+				ret = t.appendLine(ret, t.partial)
+				t.partial = nil
+			}
+			if err == io.EOF {
+				return ret, nil
+			}
+			return ret, err
+		}
+
+		line := chunk
+		if len(t.partial) > 0 {
+			line = append(t.partial, chunk...)
+			t.partial = nil
+		}
+		ret = t.appendLine(ret, line)
+	}
+}
+
+func (t *logTail) appendLine(lines []string, line []byte) []string {
+	s := strings.TrimRight(string(line), "\r\n")
+	if t.include != nil && !t.include.MatchString(s) {
+		return lines
+	}
+	if t.exclude != nil && t.exclude.MatchString(s) {
+		return lines
+	}
+	return append(lines, s)
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	rmm "github.com/wh1te909/rmmagent/shared"
+)
+
+const maxBannerSize = 4096
+
+// TCPCheck connects to host:port, optionally sends a probe and matches the response against a regex
+func (a *WindowsAgent) TCPCheck(data rmm.Check, r *resty.Client) {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	addr := net.JoinHostPort(data.Host, strconv.Itoa(data.Port))
+
+	status := "passing"
+	var (
+		latency float64
+		banner  string
+		output  string
+	)
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, time.Duration(timeout)*time.Second)
+	if err != nil {
+		status = "failing"
+		output = err.Error()
+	} else {
+		defer conn.Close()
+		latency = float64(time.Since(start).Microseconds()) / 1000
+		output = fmt.Sprintf("Connected to %s in %.2f ms", addr, latency)
+
+		if data.Send != "" || data.Expect != "" {
+			banner, err = tcpProbe(conn, data.Send, time.Duration(timeout)*time.Second)
+			if err != nil && banner == "" {
+				status = "failing"
+				output = fmt.Sprintf("%s, no response: %v", output, err)
+			} else if data.Expect != "" {
+				re, rerr := regexp.Compile(data.Expect)
+				if rerr != nil {
+					status = "failing"
+					output = fmt.Sprintf("Invalid expect regex: %v", rerr)
+				} else if !re.MatchString(banner) {
+					status = "failing"
+					output = fmt.Sprintf("%s, response did not match %q", output, data.Expect)
+				}
+			}
+		}
+	}
+
+	payload := map[string]interface{}{
+		"id":         data.CheckPK,
+		"status":     status,
+		"latency_ms": latency,
+		"banner":     banner,
+		"output":     output,
+	}
+
+	a.SendCheckResult(payload, data.AssignedTasks, r)
+}
+
+// tcpProbe sends an optional probe and reads whatever the server sends back
+func tcpProbe(conn net.Conn, send string, timeout time.Duration) (string, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if send != "" {
+		// allow escapes like \r\n in the probe
+		if unquoted, err := strconv.Unquote(`"` + send + `"`); err == nil {
+			send = unquoted
+		}
+		if _, err := conn.Write([]byte(send)); err != nil {
+			return "", err
+		}
+	}
+
+	buf := make([]byte, maxBannerSize)
+	n, err := conn.Read(buf)
+	return string(buf[:n]), err
+}
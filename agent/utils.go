@@ -2,8 +2,10 @@ package agent
 
 import (
 	"archive/zip"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"math/rand"
 	"net"
@@ -211,3 +213,50 @@ func Unzip(src, dest string) error {
 	}
 	return nil
 }
+
+// CheckDataFile returns the path to a file used to persist check state between runs
+func (a *WindowsAgent) CheckDataFile(name string) string {
+	dir := filepath.Join(a.ProgramDir, "checkdata")
+	if !FileExists(dir) {
+		if err := os.MkdirAll(dir, 0775); err != nil {
+			a.Logger.Debugln(err)
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+// LoadJSON reads a json file into v
+func LoadJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// SaveJSON writes v to a json file, replacing it atomically so readers never see a partial file
+func SaveJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// unique temp name so concurrent writers of the same file can't clobber each other's partial writes
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -12,7 +12,7 @@ func (a *WindowsAgent) RunAsService() {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go a.WinAgentSvc()
-	go a.CheckRunner()
+	go a.FileIntegrityWatcher()
 	wg.Wait()
 }
 
@@ -171,6 +171,7 @@ func (a *WindowsAgent) CheckIn(mode string) {
 	if rerr != nil {
 		a.Logger.Debugln("Checkin:", rerr)
 	}
+	a.countCheckIn(mode, rerr)
 }
 
 func randRange(min, max int) int {
@@ -6,6 +6,7 @@ require (
 	github.com/StackExchange/wmi v0.0.0-20190523213315-cbe66965904d
 	github.com/capnspacehook/taskmaster v0.0.0-20201022195506-c2d8b114cec0
 	github.com/elastic/go-sysinfo v1.6.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-ole/go-ole v1.2.5
 	github.com/go-resty/resty/v2 v2.5.0
 	github.com/gonutz/w32/v2 v2.2.0
@@ -16,5 +17,6 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/ugorji/go/codec v1.2.4
 	github.com/wh1te909/go-win64api v0.0.0-20201021040544-8fba2a0fc3d0
+	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
 	golang.org/x/sys v0.0.0-20210122235752-a8b976e07c7b
 )
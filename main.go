@@ -65,9 +65,9 @@ func main() {
 	case "winagentsvc":
 		a.RunAsService()
 	case "runchecks":
-		a.RunChecks(true)
+		a.TriggerOrRunChecks(true)
 	case "checkrunner":
-		a.RunChecks(false)
+		a.TriggerOrRunChecks(false)
 	case "sysinfo":
 		a.GetWMI()
 	case "software":
@@ -92,29 +92,96 @@ type CheckInfo struct {
 	Interval int `json:"check_interval"`
 }
 
+type SensorThreshold struct {
+	Match     string  `json:"match"`
+	WarnAbove float64 `json:"warn_above"`
+	FailAbove float64 `json:"fail_above"`
+	FailBelow float64 `json:"fail_below"`
+}
+
 type Check struct {
-	Script           Script         `json:"script"`
-	AssignedTasks    []AssignedTask `json:"assigned_tasks"`
-	CheckPK          int            `json:"id"`
-	CheckType        string         `json:"check_type"`
-	Status           string         `json:"status"`
-	Threshold        int            `json:"threshold"`
-	Disk             string         `json:"disk"`
-	IP               string         `json:"ip"`
-	ScriptArgs       []string       `json:"script_args"`
-	Timeout          int            `json:"timeout"`
-	ServiceName      string         `json:"svc_name"`
-	PassStartPending bool           `json:"pass_if_start_pending"`
-	PassNotExist     bool           `json:"pass_if_svc_not_exist"`
-	RestartIfStopped bool           `json:"restart_if_stopped"`
-	LogName          string         `json:"log_name"`
-	EventID          int            `json:"event_id"`
-	EventIDWildcard  bool           `json:"event_id_is_wildcard"`
-	EventType        string         `json:"event_type"`
-	EventSource      string         `json:"event_source"`
-	EventMessage     string         `json:"event_message"`
-	FailWhen         string         `json:"fail_when"`
-	SearchLastDays   int            `json:"search_last_days"`
+	Script           Script            `json:"script"`
+	AssignedTasks    []AssignedTask    `json:"assigned_tasks"`
+	CheckPK          int               `json:"id"`
+	CheckType        string            `json:"check_type"`
+	Status           string            `json:"status"`
+	Threshold        int               `json:"threshold"`
+	Disk             string            `json:"disk"`
+	IP               string            `json:"ip"`
+	ScriptArgs       []string          `json:"script_args"`
+	Timeout          int               `json:"timeout"`
+	ServiceName      string            `json:"svc_name"`
+	PassStartPending bool              `json:"pass_if_start_pending"`
+	PassNotExist     bool              `json:"pass_if_svc_not_exist"`
+	RestartIfStopped bool              `json:"restart_if_stopped"`
+	LogName          string            `json:"log_name"`
+	EventID          int               `json:"event_id"`
+	EventIDWildcard  bool              `json:"event_id_is_wildcard"`
+	EventType        string            `json:"event_type"`
+	EventSource      string            `json:"event_source"`
+	EventMessage     string            `json:"event_message"`
+	FailWhen         string            `json:"fail_when"`
+	SearchLastDays   int               `json:"search_last_days"`
+	Paths            []string          `json:"paths"`
+	WatchPaths       bool              `json:"watch_paths"`
+	UpdateBaseline   bool              `json:"update_baseline"`
+	RunInterval      int               `json:"run_interval"`
+	Host             string            `json:"host"`
+	Port             int               `json:"port"`
+	Send             string            `json:"send"`
+	Expect           string            `json:"expect"`
+	URL              string            `json:"url"`
+	Method           string            `json:"method"`
+	Headers          map[string]string `json:"headers"`
+	Body             string            `json:"body"`
+	StatusMin        int               `json:"status_min"`
+	StatusMax        int               `json:"status_max"`
+	BodyContains     string            `json:"body_contains"`
+	MaxResponseMS    int               `json:"max_response_ms"`
+	SkipTLSVerify    bool              `json:"skip_tls_verify"`
+	TLSServerName    string            `json:"tls_server_name"`
+	CACert           string            `json:"ca_cert"`
+	WarnDays         int               `json:"warn_days"`
+	CritDays         int               `json:"crit_days"`
+	CertPassword     string            `json:"cert_password"`
+	ScanCertStore    bool              `json:"scan_cert_store"`
+	ProcessName      string            `json:"process_name"`
+	CmdlineRegex     string            `json:"cmdline_regex"`
+	ProcessUser      string            `json:"process_user"`
+	MinCount         int               `json:"min_count"`
+	MaxCount         *int              `json:"max_count"` // nil is no limit, 0 asserts the process isn't running
+	CPUThreshold     float64           `json:"cpu_threshold"`
+	RSSThresholdMB   int               `json:"rss_threshold_mb"`
+	Samples          int               `json:"consecutive_samples"`
+	RestartCommand   string            `json:"restart_command"`
+	IncludeRegex     string            `json:"include_regex"`
+	ExcludeRegex     string            `json:"exclude_regex"`
+	MatchCount       int               `json:"match_count"`
+	PingCount        int               `json:"ping_count"`
+	PingIntervalMS   int               `json:"ping_interval_ms"`
+	LossWarn         float64           `json:"loss_warn"`
+	LossFail         float64           `json:"loss_fail"`
+	RTTWarnMS        float64           `json:"rtt_warn_ms"`
+	RTTFailMS        float64           `json:"rtt_fail_ms"`
+	RecordType       string            `json:"record_type"`
+	Resolver         string            `json:"resolver"`
+	ExpectedAnswers  []string          `json:"expected_answers"`
+	FullWarnDays     float64           `json:"full_warn_days"`
+	FullFailDays     float64           `json:"full_fail_days"`
+	SampleSeconds    int               `json:"sample_seconds"`
+	Interfaces       []string          `json:"interfaces"`
+	MaxMbps          float64           `json:"max_mbps"`
+	MaxErrorPercent  float64           `json:"max_error_percent"`
+	SensorThresholds []SensorThreshold `json:"sensor_thresholds"`
+	MaxAgeHours      float64           `json:"max_age_hours"`
+	MinSizeBytes     int64             `json:"min_size_bytes"`
+	Directory        string            `json:"directory"`
+	MaxDepth         int               `json:"max_depth"`
+	MaxSizeMB        int64             `json:"max_size_mb"`
+	MaxFiles         int64             `json:"max_files"`
+	MaxGrowthMB      int64             `json:"max_growth_mb"`
+	ParentCheck      int               `json:"parent_check"`
+	Command          string            `json:"command"`
 }
 
 type AllChecks struct {